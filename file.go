@@ -1,8 +1,10 @@
 package go_utils
 
 import (
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	log "github.com/sirupsen/logrus"
 	xlog "github.com/sirupsen/logrus"
@@ -59,6 +61,63 @@ func CreateOrRemoveFile(xl *xlog.Logger, filename string) (file *os.File, err er
 	return
 }
 
+// WriteFileAtomic writes data to filename atomically, filename 要么是原来的内容，要么是完整的新内容。
+// 先写入同目录下的临时文件并 fsync，然后 rename 覆盖 filename，最后 fsync 目录保证 rename 落盘。
+// 临时文件按照 perm 创建，和 os.WriteFile 一样受 umask 影响。
+// 同时会创建相应的目录
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(filename)
+	if err = os.MkdirAll(dir, 0775); err != nil {
+		return
+	}
+
+	tmp, err := createTemp(dir, "."+filepath.Base(filename)+".tmp", perm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(tmp.Name(), filename); err != nil {
+		return
+	}
+	return syncDir(dir)
+}
+
+// createTemp is like os.CreateTemp, but creates the file with perm instead of 0600
+func createTemp(dir, prefix string, perm os.FileMode) (f *os.File, err error) {
+	for i := 0; i < 10000; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			return
+		}
+	}
+	return
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 func IsFileExists(filename string) (bool, error) {
 	_, err := os.Stat(filename)
 	if err == nil {
@@ -0,0 +1,34 @@
+package go_utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "a", "b.txt")
+
+	assert.NoError(t, WriteFileAtomic(filename, []byte("hello"), 0644))
+	b, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// 覆盖写
+	assert.NoError(t, WriteFileAtomic(filename, []byte("world"), 0644))
+	b, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(b))
+
+	// 不会留下临时文件
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	info, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
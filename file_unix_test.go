@@ -0,0 +1,23 @@
+//go:build unix
+
+package go_utils
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func TestWriteFileAtomic_Umask(t *testing.T) {
+	old := syscall.Umask(022)
+	defer syscall.Umask(old)
+
+	filename := filepath.Join(t.TempDir(), "marker.txt")
+	assert.NoError(t, WriteFileAtomic(filename, []byte("1"), 0666))
+	info, err := os.Stat(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
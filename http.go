@@ -0,0 +1,69 @@
+package go_utils
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+type reqidCtxKey struct{}
+
+// WithReqid returns a copy of ctx carrying reqid
+func WithReqid(ctx context.Context, reqid string) context.Context {
+	return context.WithValue(ctx, reqidCtxKey{}, reqid)
+}
+
+// ReqidFromContext returns the reqid carried by ctx
+func ReqidFromContext(ctx context.Context) (reqid string, ok bool) {
+	reqid, ok = ctx.Value(reqidCtxKey{}).(string)
+	return
+}
+
+// LoggerFromContext returns a logrus entry tagged with the reqid carried by ctx.
+// ctx 中没有 reqid 时，返回不带 reqid 字段的 entry
+func LoggerFromContext(ctx context.Context) *logrus.Entry {
+	l := logrus.NewEntry(logrus.StandardLogger()).WithContext(ctx)
+	if reqid, ok := ReqidFromContext(ctx); ok {
+		l = l.WithField(ReqidKey, reqid)
+	}
+	return l
+}
+
+// ReqidMiddleware 读取请求中的 X-Reqid（没有则生成一个），放入 request context，并在 response header 中返回
+func ReqidMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqid := r.Header.Get(ReqidKey)
+		if reqid == "" {
+			reqid = NewReqid()
+		}
+		w.Header().Set(ReqidKey, reqid)
+		next.ServeHTTP(w, r.WithContext(WithReqid(r.Context(), reqid)))
+	})
+}
+
+// ReqidTransport is a http.RoundTripper that sets X-Reqid on outgoing requests.
+// 优先使用请求中已有的 header，其次是 request context 中的 reqid，都没有则生成一个
+type ReqidTransport struct {
+	Base http.RoundTripper // 为 nil 时使用 http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *ReqidTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if r.Header.Get(ReqidKey) != "" {
+		return base.RoundTrip(r)
+	}
+
+	reqid, ok := ReqidFromContext(r.Context())
+	if !ok {
+		reqid = NewReqid()
+	}
+	// RoundTripper 不应该修改传入的 request
+	r2 := r.Clone(r.Context())
+	r2.Header.Set(ReqidKey, reqid)
+	return base.RoundTrip(r2)
+}
@@ -0,0 +1,67 @@
+package go_utils
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golib/assert"
+	"github.com/sirupsen/logrus"
+)
+
+func TestReqidMiddleware(t *testing.T) {
+	var got string
+	h := ReqidMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ReqidFromContext(r.Context())
+	}))
+
+	// 复用请求中的 reqid
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(ReqidKey, "abc")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, "abc", got)
+	assert.Equal(t, "abc", w.Header().Get(ReqidKey))
+
+	// 生成新的 reqid
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.NotEqual(t, "", got)
+	assert.Equal(t, got, w.Header().Get(ReqidKey))
+	_, _, err := DecodeReqid(got)
+	assert.NoError(t, err)
+}
+
+func TestReqidTransport(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(ReqidKey)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &ReqidTransport{}}
+
+	req, err := http.NewRequestWithContext(WithReqid(context.Background(), "from-ctx"), "GET", srv.URL, nil)
+	assert.NoError(t, err)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "from-ctx", got)
+	assert.Equal(t, "", req.Header.Get(ReqidKey))
+
+	resp, err = client.Get(srv.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.NotEqual(t, "", got)
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	buf := bytes.Buffer{}
+	out := logrus.StandardLogger().Out
+	logrus.SetOutput(&buf)
+	defer logrus.SetOutput(out)
+
+	LoggerFromContext(WithReqid(context.Background(), "abc")).Info("hello")
+	assert.Contains(t, buf.String(), ReqidKey+"=abc")
+}
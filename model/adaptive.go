@@ -0,0 +1,133 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveConcurrency adjusts the number of active consumers in AIMD style:
+// 每个周期内，平均 consume 耗时和错误率都没有超过目标时，worker 数量 +1；超过时乘以 DecreaseFactor。
+// worker 数量始终在 [Min, Max] 之间，初始值为创建时的 consumer 数量。
+type AdaptiveConcurrency struct {
+	Min             int
+	Max             int
+	TargetLatency   time.Duration // 平均 consume 耗时的目标，<= 0 表示不检查
+	TargetErrorRate float64       // consume 错误率的目标，<= 0 表示不检查
+	Interval        time.Duration // 调整的周期，<= 0 时为 1s
+	DecreaseFactor  float64       // 减少时的倍数，取值 (0, 1)，否则为 0.5
+}
+
+func (c AdaptiveConcurrency) validate() error {
+	if c.Min <= 0 || c.Max < c.Min {
+		return errors.New("invalid adaptive concurrency, need 0 < Min <= Max")
+	}
+	return nil
+}
+
+// concurrencyController decides how many workers are active, index < limit 的 worker 才会 consume
+type concurrencyController struct {
+	cfg      AdaptiveConcurrency
+	onChange func(limit int) // limit 变化后调用，可以为 nil
+
+	m       sync.Mutex
+	limit   int
+	changed chan struct{} // limit 变化时关闭，并替换成新的 chan
+
+	// 当前周期的统计，atomic 访问
+	count   int64
+	errs    int64
+	latency int64 // 总耗时，ns
+}
+
+func newConcurrencyController(cfg AdaptiveConcurrency, initial int) *concurrencyController {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.DecreaseFactor <= 0 || cfg.DecreaseFactor >= 1 {
+		cfg.DecreaseFactor = 0.5
+	}
+	return &concurrencyController{
+		cfg:     cfg,
+		limit:   clamp(initial, cfg.Min, cfg.Max),
+		changed: make(chan struct{}),
+	}
+}
+
+// state returns whether the index-th worker is active, 不活跃的 worker 等待 changed 被关闭后再检查
+func (c *concurrencyController) state(index int) (active bool, changed <-chan struct{}) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return index < c.limit, c.changed
+}
+
+// Limit returns the current number of active workers
+func (c *concurrencyController) Limit() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.limit
+}
+
+// observe records a consume
+func (c *concurrencyController) observe(d time.Duration, err error) {
+	atomic.AddInt64(&c.count, 1)
+	atomic.AddInt64(&c.latency, int64(d))
+	if err != nil {
+		atomic.AddInt64(&c.errs, 1)
+	}
+}
+
+// run adjusts limit every interval until stop is closed
+func (c *concurrencyController) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.adjust()
+		}
+	}
+}
+
+func (c *concurrencyController) adjust() {
+	count := atomic.SwapInt64(&c.count, 0)
+	errs := atomic.SwapInt64(&c.errs, 0)
+	latency := atomic.SwapInt64(&c.latency, 0)
+	if count == 0 {
+		return
+	}
+
+	overloaded := (c.cfg.TargetLatency > 0 && time.Duration(latency/count) > c.cfg.TargetLatency) ||
+		(c.cfg.TargetErrorRate > 0 && float64(errs)/float64(count) > c.cfg.TargetErrorRate)
+
+	c.m.Lock()
+	limit := c.limit + 1
+	if overloaded {
+		limit = int(float64(c.limit) * c.cfg.DecreaseFactor)
+	}
+	limit = clamp(limit, c.cfg.Min, c.cfg.Max)
+	changed := limit != c.limit
+	if changed {
+		c.limit = limit
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+	c.m.Unlock()
+
+	if changed && c.onChange != nil {
+		c.onChange(limit)
+	}
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
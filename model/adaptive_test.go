@@ -0,0 +1,148 @@
+package model
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+func TestConcurrencyController_Adjust(t *testing.T) {
+	c := newConcurrencyController(AdaptiveConcurrency{Min: 2, Max: 10, TargetLatency: time.Millisecond, TargetErrorRate: 0.1}, 4)
+	assert.Equal(t, 4, c.Limit())
+
+	// 没有数据时不调整
+	c.adjust()
+	assert.Equal(t, 4, c.Limit())
+
+	// 加法增加
+	c.observe(100*time.Microsecond, nil)
+	_, changed := c.state(4)
+	c.adjust()
+	assert.Equal(t, 5, c.Limit())
+	assert.True(t, isDone(changed))
+	active, _ := c.state(4)
+	assert.True(t, active)
+
+	// 耗时超过目标，乘法减少
+	c.observe(2*time.Millisecond, nil)
+	c.adjust()
+	assert.Equal(t, 2, c.Limit())
+
+	// 错误率超过目标，不会少于 Min
+	c.observe(100*time.Microsecond, errors.New("failed"))
+	c.adjust()
+	assert.Equal(t, 2, c.Limit())
+}
+
+func TestProducerConsumer_Run_AdaptiveConcurrency(t *testing.T) {
+	produce := func(n int64) ProduceFunc {
+		var i int64
+		return func() (Entry, error) {
+			if i++; i > n {
+				return nil, ErrFinished
+			}
+			return i, nil
+		}
+	}
+
+	// 耗时超过目标，减少到 Min
+	var consumed int64
+	slow := func(Entry) error {
+		atomic.AddInt64(&consumed, 1)
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	}
+	cfg := AdaptiveConcurrency{Min: 1, Max: 8, TargetLatency: time.Millisecond, Interval: 5 * time.Millisecond}
+	p, err := NewProducerConsumer(produce(200), slow, 8, WithAdaptiveConcurrency(cfg))
+	assert.NoError(t, err)
+	assert.Equal(t, 8, p.Workers())
+	assert.NoError(t, p.Run())
+	assert.Equal(t, int64(200), consumed)
+	assert.Equal(t, 1, p.Workers())
+
+	// 耗时没有超过目标，增加到 Max
+	fast := func(Entry) error {
+		time.Sleep(100 * time.Microsecond)
+		return nil
+	}
+	cfg.TargetLatency = 50 * time.Millisecond
+	cfg.Max = 4
+	p, err = NewProducerConsumer(produce(1000), fast, 1, WithAdaptiveConcurrency(cfg))
+	assert.NoError(t, err)
+	assert.NoError(t, p.Run())
+	assert.Equal(t, 4, p.Workers())
+
+	_, err = NewProducerConsumer(produce(1), fast, 1, WithAdaptiveConcurrency(AdaptiveConcurrency{Min: 2, Max: 1}))
+	assert.Error(t, err)
+}
+
+type sleepConsumer struct {
+	d        time.Duration
+	failAt   int64
+	consumed int64
+}
+
+func (c *sleepConsumer) Consume(entry E) error {
+	time.Sleep(c.d)
+	if entry.Marker() == c.failAt {
+		return errors.New("consume failed")
+	}
+	atomic.AddInt64(&c.consumed, 1)
+	return nil
+}
+
+func TestProducerConsumerRunner_Run_AdaptiveConcurrency(t *testing.T) {
+	// 耗时超过目标，减少到 Min
+	consumer := &sleepConsumer{d: 2 * time.Millisecond}
+	adaptive := AdaptiveConcurrency{Min: 1, Max: 8, TargetLatency: time.Millisecond, Interval: 5 * time.Millisecond}
+	cfg := ProducerConsumerConfig{
+		Produce:  &limitProducer{limit: 200},
+		Consume:  consumer,
+		Num:      8,
+		Adaptive: &adaptive,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, p.Workers())
+	assert.NoError(t, p.Run())
+	assert.Equal(t, int64(200), consumer.consumed)
+	assert.Equal(t, 1, p.Workers())
+	assert.Equal(t, 1, p.Status().Workers)
+
+	// 耗时没有超过目标，增加到 Max
+	consumer = &sleepConsumer{d: 100 * time.Microsecond}
+	adaptive.TargetLatency = 50 * time.Millisecond
+	adaptive.Max = 4
+	cfg.Produce = &limitProducer{limit: 1000}
+	cfg.Consume = consumer
+	cfg.Num = 1
+	p, err = NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Run())
+	assert.Equal(t, int64(1000), consumer.consumed)
+	assert.Equal(t, 4, p.Workers())
+
+	cfg.Adaptive = &AdaptiveConcurrency{Min: 2, Max: 1}
+	_, err = NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.Error(t, err)
+}
+
+func TestProducerConsumerRunner_Run_AdaptiveConcurrencyErr(t *testing.T) {
+	// 唯一活跃的 consumer 失败后，不活跃的 consumer 不会一直等待
+	consumer := &sleepConsumer{failAt: 5}
+	cfg := ProducerConsumerConfig{
+		Produce:  &limitProducer{limit: 100},
+		Consume:  consumer,
+		Num:      1,
+		Adaptive: &AdaptiveConcurrency{Min: 1, Max: 4, Interval: time.Hour},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	err = p.Run()
+	assert.Error(t, err)
+	assert.Equal(t, int64(5), p.marker)
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBatchSize = 100
+	defaultBatchWait = time.Second
+)
+
+// BatchConsumer consumes entries in batch, 用于批量写入、批量删除等批量处理更便宜的场景
+type BatchConsumer interface {
+	ConsumeBatch([]E) error
+}
+
+// doConsumeBatch is like doConsume, but consumes up to batchSize entries at a time.
+// 一批失败时，这一批都没有完成，marker 不会超过这一批中最小的 marker。
+func (p *ProducerConsumerRunner) doConsumeBatch(ctx context.Context, i int) {
+	xl := p.xl
+
+	done := abandonDone(ctx, p.cancelPolicy, p.abort)
+	for {
+		var batch []trackedEntry
+		var finished bool
+		if p.consumerActive(done, i) && p.consumerResumed(ctx, done) {
+			batch, finished = p.collectBatch(done)
+		}
+		if isDone(done) {
+			xl.Info("consumer exit because of ctx done, consumer index: ", i)
+			atomic.StoreInt32(&p.canceled, 1)
+			p.setStopped(stopCause(ctx, p.abort))
+			return
+		}
+		if len(batch) > 0 && !p.consumeBatch(ctx, done, i, batch) {
+			return
+		}
+		if finished {
+			xl.Info("consumer exit because of finished or producer err, consumer index: ", i)
+			safeClose(p.finished)
+			return
+		}
+	}
+}
+
+// collectBatch 等待第一个 entry，然后在 batchWait 内最多收集 batchSize 个。
+// buf 被关闭时 finished 为 true
+func (p *ProducerConsumerRunner) collectBatch(done <-chan struct{}) (batch []trackedEntry, finished bool) {
+	select {
+	case <-done:
+		return
+	case te, ok := <-p.buf:
+		if !ok {
+			return nil, true
+		}
+		batch = append(batch, te)
+	}
+
+	timer := time.NewTimer(p.batchWait)
+	defer timer.Stop()
+	for len(batch) < p.batchSize {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			return
+		case te, ok := <-p.buf:
+			if !ok {
+				finished = true
+				return
+			}
+			batch = append(batch, te)
+		}
+	}
+	return
+}
+
+// consumeBatch 返回 false 表示 consumer 需要退出
+func (p *ProducerConsumerRunner) consumeBatch(ctx context.Context, done <-chan struct{}, i int, batch []trackedEntry) bool {
+	xl := p.xl
+
+	entries := make([]E, len(batch))
+	for j, te := range batch {
+		entries[j] = te.entry
+		// 按照 entry 的数量限速
+		if !p.limiters.waitConsume(done, i) {
+			xl.Info("consumer exit because of ctx done, consumer index: ", i)
+			atomic.StoreInt32(&p.canceled, 1)
+			p.setStopped(stopCause(ctx, p.abort))
+			return false
+		}
+	}
+
+	start := time.Now()
+	attempts, err := p.retryPolicy.do(done, func() error {
+		return protect(entries, func() error {
+			return p.batchConsumer.ConsumeBatch(entries)
+		})
+	}, func(retry int, err error) {
+		xl.Infof("consume batch failed, retry: %v, consumer index: %v, size: %v, err: %v", retry, i, len(batch), err)
+		p.speedCounter.IncreaseRetry()
+	})
+	// 等待重试时被取消，这一批都没有完成
+	if err == errRetryInterrupted {
+		xl.Info("consumer exit because of ctx done while retrying, consumer index: ", i)
+		atomic.StoreInt32(&p.canceled, 1)
+		p.setStopped(stopCause(ctx, p.abort))
+		return false
+	}
+	latency := time.Since(start)
+	if p.ctrl != nil {
+		p.ctrl.observe(latency, err)
+	}
+	if err != nil {
+		p.stats[i].observe(latency, 0, len(batch))
+		for _, te := range batch {
+			if err := p.deadLetter.handle(te.entry, err, attempts, atomic.LoadInt64(&p.processed)); err != nil {
+				xl.Infof("consumer exit because of err, consumer index: %v, attempts: %v, err: %v", i, attempts, err)
+				xl.Infof("marker: %v, batch size: %v", minMarker(entries), len(batch))
+				p.addConsumeErr(i, entries, err)
+				p.setStopped(err)
+				safeClose(p.stop) // consumer 关闭 stop chan，而不要关闭 buf chan，避免 panic。
+				return false
+			}
+			xl.Infof("consume batch failed, put into dead letter, consumer index: %v, entry: %v", i, te.entry)
+			p.complete(te, nil, true)
+		}
+		return true
+	}
+
+	p.stats[i].observe(latency, len(batch), 0)
+	atomic.AddInt64(&p.processed, int64(len(batch)))
+	for _, te := range batch {
+		p.complete(te, nil, false)
+	}
+	return true
+}
+
+func minMarker(entries []E) int64 {
+	marker := entries[0].Marker()
+	for _, entry := range entries[1:] {
+		if entry.Marker() < marker {
+			marker = entry.Marker()
+		}
+	}
+	return marker
+}
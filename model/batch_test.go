@@ -0,0 +1,132 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+type recordBatchConsumer struct {
+	mu      sync.Mutex
+	sizes   []int
+	total   int
+	failAt  int64
+	sleepOn bool
+}
+
+func (c *recordBatchConsumer) ConsumeBatch(entries []E) error {
+	if c.sleepOn {
+		time.Sleep(time.Millisecond)
+	}
+	for _, entry := range entries {
+		if entry.Marker() == c.failAt {
+			return errors.New("batch failed")
+		}
+	}
+	c.mu.Lock()
+	c.sizes = append(c.sizes, len(entries))
+	c.total += len(entries)
+	c.mu.Unlock()
+	return nil
+}
+
+func TestProducerConsumerRunner_Run_Batch(t *testing.T) {
+	consumer := &recordBatchConsumer{sleepOn: true}
+	cfg := ProducerConsumerConfig{
+		Produce:      &limitProducer{limit: 100},
+		Num:          2,
+		BatchConsume: consumer,
+		BatchSize:    10,
+		BatchWait:    50 * time.Millisecond,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	p.Run()
+
+	assert.Equal(t, 100, consumer.total)
+	for _, size := range consumer.sizes {
+		assert.True(t, size > 0 && size <= 10, size)
+	}
+	// speedometer 按 entry 计数，而不是按 batch
+	assert.Equal(t, int64(100), p.speedCounter.Processed())
+	assert.Equal(t, int64(100), p.processed)
+	assert.Equal(t, int64(0), p.marker)
+}
+
+func TestProducerConsumerRunner_Run_BatchWait(t *testing.T) {
+	consumer := &recordBatchConsumer{}
+	cfg := ProducerConsumerConfig{
+		Produce:      &limitProducer{limit: 3},
+		Num:          1,
+		BatchConsume: consumer,
+		BatchSize:    100,
+		BatchWait:    10 * time.Millisecond,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	p.Run()
+	// buf 关闭后不会等满 BatchSize
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 3, consumer.total)
+}
+
+func TestProducerConsumerRunner_Run_BatchErr(t *testing.T) {
+	consumer := &recordBatchConsumer{failAt: 25}
+	cfg := ProducerConsumerConfig{
+		Produce:      &limitProducer{limit: 100},
+		Num:          1,
+		BatchConsume: consumer,
+		BatchSize:    10,
+		BatchWait:    time.Second,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	p.Run()
+
+	// 失败的一批中最小的 marker
+	assert.Equal(t, 20, consumer.total)
+	assert.Equal(t, int64(21), p.marker)
+}
+
+func TestNewProducerConsumerRunner_BatchWithSink(t *testing.T) {
+	cfg := ProducerConsumerConfig{
+		Produce:      &limitProducer{limit: 10},
+		Num:          1,
+		BatchConsume: &recordBatchConsumer{},
+		Transform:    &sleepTransformer{},
+		Sink:         &recordSink{},
+	}
+	_, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.Error(t, err)
+}
+
+func TestProducerConsumerRunner_Run_BatchRetryInterrupted(t *testing.T) {
+	consumer := &recordBatchConsumer{failAt: 1}
+	cfg := ProducerConsumerConfig{
+		Produce:      &limitProducer{limit: 20},
+		Num:          1,
+		BatchConsume: consumer,
+		BatchSize:    5,
+		BatchWait:    time.Millisecond,
+		CancelPolicy: CancelAbandon,
+		RetryPolicy:  RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	report, err := p.RunWithReport(ctx)
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Equal(t, StopCanceled, report.StopReason)
+	assert.Equal(t, int64(0), report.Failed)
+	assert.Equal(t, int64(1), report.Marker)
+}
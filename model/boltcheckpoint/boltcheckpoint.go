@@ -0,0 +1,145 @@
+/*
+Package boltcheckpoint saves checkpoints of model.ProducerConsumerRunner to a bbolt database.
+单独放在一个包中，不使用 bbolt 时不需要依赖它。
+*/
+package boltcheckpoint
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/wanfadong/go-utils/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	checkpointBucket = []byte("checkpoints")
+	currentKey       = []byte("current")
+	historyBucket    = []byte("history")
+)
+
+// Store is a model.CheckpointStore saving checkpoints to a bbolt database, 多个任务可以共用一个数据库，按照 job 区分
+type Store struct {
+	MaxHistory int // 保留的历史数量，<= 0 时使用默认值
+
+	db  *bolt.DB
+	job []byte
+}
+
+// OpenDB opens (or creates) a bbolt database used by Store
+func OpenDB(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0666, &bolt.Options{Timeout: time.Second})
+}
+
+// NewStore returns a Store of job, db 由调用方负责关闭
+func NewStore(db *bolt.DB, job string) (s *Store, err error) {
+	if job == "" {
+		err = errors.New("empty job name")
+		return
+	}
+	s = &Store{db: db, job: []byte(job)}
+	return
+}
+
+// Load implements model.CheckpointStore
+func (s *Store) Load() (cp model.Checkpoint, exists bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := s.jobBucket(tx)
+		if b == nil {
+			return nil
+		}
+		v := b.Get(currentKey)
+		if v == nil {
+			return nil
+		}
+		exists = true
+		return json.Unmarshal(v, &cp)
+	})
+	return
+}
+
+// Save implements model.CheckpointStore
+func (s *Store) Save(cp model.Checkpoint) error {
+	v, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(checkpointBucket)
+		if err != nil {
+			return err
+		}
+		b, err := root.CreateBucketIfNotExists(s.job)
+		if err != nil {
+			return err
+		}
+		if err = b.Put(currentKey, v); err != nil {
+			return err
+		}
+
+		h, err := b.CreateBucketIfNotExists(historyBucket)
+		if err != nil {
+			return err
+		}
+		seq, err := h.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err = h.Put(seqKey(seq), v); err != nil {
+			return err
+		}
+		// 删除超出数量的历史
+		if max := uint64(maxHistory(s.MaxHistory)); seq > max {
+			return h.Delete(seqKey(seq - max))
+		}
+		return nil
+	})
+}
+
+// History implements model.CheckpointStore
+func (s *Store) History() (cps []model.Checkpoint, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := s.jobBucket(tx)
+		if b == nil {
+			return nil
+		}
+		h := b.Bucket(historyBucket)
+		if h == nil {
+			return nil
+		}
+		return h.ForEach(func(k, v []byte) error {
+			var cp model.Checkpoint
+			if err := json.Unmarshal(v, &cp); err != nil {
+				return err
+			}
+			cps = append(cps, cp)
+			return nil
+		})
+	})
+	return
+}
+
+func (s *Store) jobBucket(tx *bolt.Tx) *bolt.Bucket {
+	root := tx.Bucket(checkpointBucket)
+	if root == nil {
+		return nil
+	}
+	return root.Bucket(s.job)
+}
+
+// seqKey 使用大端序，保证 ForEach 按照保存的顺序遍历
+func seqKey(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
+
+func maxHistory(max int) int {
+	if max <= 0 {
+		return model.DefaultMaxCheckpointHistory
+	}
+	return max
+}
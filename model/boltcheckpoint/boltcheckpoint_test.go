@@ -0,0 +1,51 @@
+package boltcheckpoint
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	"github.com/wanfadong/go-utils/model"
+)
+
+var _ model.CheckpointStore = (*Store)(nil)
+
+func testStore(t *testing.T, s *Store, history int) {
+	_, exists, err := s.Load()
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	for i := int64(1); i <= 5; i++ {
+		assert.NoError(t, s.Save(model.Checkpoint{Marker: i, Processed: i * 10, Time: time.Now()}))
+	}
+	cp, exists, err := s.Load()
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, int64(5), cp.Marker)
+	assert.Equal(t, int64(50), cp.Processed)
+
+	cps, err := s.History()
+	assert.NoError(t, err)
+	assert.Equal(t, history, len(cps))
+	assert.Equal(t, int64(5), cps[len(cps)-1].Marker)
+}
+
+func TestStore(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "checkpoint.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	s1, err := NewStore(db, "job1")
+	assert.NoError(t, err)
+	s1.MaxHistory = 2
+	testStore(t, s1, 2)
+
+	// 不同的 job 互不影响
+	s2, err := NewStore(db, "job2")
+	assert.NoError(t, err)
+	testStore(t, s2, 5)
+
+	_, err = NewStore(db, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,178 @@
+package model
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wanfadong/go-utils"
+)
+
+// DefaultMaxCheckpointHistory 是默认保留的历史 checkpoint 数量
+const DefaultMaxCheckpointHistory = 100
+
+// Checkpoint is a saved position of a run
+type Checkpoint struct {
+	Marker    int64     `json:"marker"`
+	Cursor    string    `json:"cursor,omitempty"`     // 使用 CursorEntry 时续处理的位置
+	HasCursor bool      `json:"has_cursor,omitempty"` // 保存的是 Cursor，此时空的 Cursor 表示从头开始（Exhausted 时除外）
+	Exhausted bool      `json:"exhausted,omitempty"`  // 使用 CursorEntry 时已经到达末尾（最后的 NextCursor 为空）并且全部处理完成，不需要续处理
+	Processed int64     `json:"processed"`            // 保存时已经 consume 成功的数量
+	Time      time.Time `json:"time"`
+	Err       string    `json:"error,omitempty"` // 导致 run 停止的错误，运行中定期保存时为空
+}
+
+// CheckpointStore persists checkpoints of a run
+type CheckpointStore interface {
+	// Load returns the latest checkpoint, 没有保存过时 exists 为 false
+	Load() (cp Checkpoint, exists bool, err error)
+	Save(cp Checkpoint) error
+	// History returns saved checkpoints, 从旧到新
+	History() ([]Checkpoint, error)
+}
+
+// FileCheckpointStore saves the marker to a plain text file, 兼容 LoadMarker。
+// 使用 CursorEntry 时保存为 "cursor:" 加上 Cursor，Cursor 为空时也是如此；Exhausted 时保存为 "exhausted"。
+// 只保存 marker 或者 Cursor，不保存历史。
+type FileCheckpointStore struct {
+	path string
+}
+
+const (
+	cursorPrefix  = "cursor:"   // FileCheckpointStore 保存 Cursor 时的前缀，用于和 int64 的 marker 区分
+	exhaustedData = "exhausted" // FileCheckpointStore 保存 Exhausted 的 checkpoint 时的内容
+)
+
+// NewFileCheckpointStore returns a FileCheckpointStore
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+// Load implements CheckpointStore
+func (s *FileCheckpointStore) Load() (cp Checkpoint, exists bool, err error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	exists = true
+	data := string(b)
+	if strings.TrimSpace(data) == exhaustedData {
+		cp.HasCursor = true
+		cp.Exhausted = true
+		return
+	}
+	if strings.HasPrefix(data, cursorPrefix) {
+		cp.Cursor = strings.TrimRight(strings.TrimPrefix(data, cursorPrefix), "\r\n")
+		cp.HasCursor = true
+		return
+	}
+	cp.Marker, err = strconv.ParseInt(strings.TrimSpace(data), 10, 64)
+	return
+}
+
+// Save implements CheckpointStore
+func (s *FileCheckpointStore) Save(cp Checkpoint) error {
+	data := strconv.FormatInt(cp.Marker, 10)
+	if cp.HasCursor || cp.Cursor != "" {
+		data = cursorPrefix + cp.Cursor
+	}
+	if cp.Exhausted {
+		data = exhaustedData
+	}
+	return go_utils.WriteFileAtomic(s.path, []byte(data), 0666)
+}
+
+// History implements CheckpointStore, 只有最新的一个
+func (s *FileCheckpointStore) History() (cps []Checkpoint, err error) {
+	cp, exists, err := s.Load()
+	if err != nil || !exists {
+		return
+	}
+	cps = []Checkpoint{cp}
+	return
+}
+
+// JSONCheckpointStore saves checkpoints to a JSON file, 包括最新的 checkpoint 和历史
+type JSONCheckpointStore struct {
+	MaxHistory int // 保留的历史数量，<= 0 时使用默认值
+
+	m    sync.Mutex
+	path string
+}
+
+type jsonCheckpoints struct {
+	Current Checkpoint   `json:"current"`
+	History []Checkpoint `json:"history"`
+}
+
+// NewJSONCheckpointStore returns a JSONCheckpointStore
+func NewJSONCheckpointStore(path string) *JSONCheckpointStore {
+	return &JSONCheckpointStore{path: path}
+}
+
+func (s *JSONCheckpointStore) read() (cps jsonCheckpoints, exists bool, err error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	exists = true
+	err = json.Unmarshal(b, &cps)
+	return
+}
+
+// Load implements CheckpointStore
+func (s *JSONCheckpointStore) Load() (cp Checkpoint, exists bool, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	cps, exists, err := s.read()
+	cp = cps.Current
+	return
+}
+
+// Save implements CheckpointStore
+func (s *JSONCheckpointStore) Save(cp Checkpoint) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	cps, _, err := s.read()
+	if err != nil {
+		return err
+	}
+	cps.Current = cp
+	cps.History = append(cps.History, cp)
+	if max := maxHistory(s.MaxHistory); len(cps.History) > max {
+		cps.History = cps.History[len(cps.History)-max:]
+	}
+
+	b, err := go_utils.GetPrettyJson(cps)
+	if err != nil {
+		return err
+	}
+	return go_utils.WriteFileAtomic(s.path, []byte(b), 0666)
+}
+
+// History implements CheckpointStore
+func (s *JSONCheckpointStore) History() ([]Checkpoint, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	cps, _, err := s.read()
+	return cps.History, err
+}
+
+func maxHistory(max int) int {
+	if max <= 0 {
+		return DefaultMaxCheckpointHistory
+	}
+	return max
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+func testCheckpointStore(t *testing.T, s CheckpointStore, history int) {
+	_, exists, err := s.Load()
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	for i := int64(1); i <= 5; i++ {
+		assert.NoError(t, s.Save(Checkpoint{Marker: i, Processed: i * 10, Time: time.Now()}))
+	}
+	cp, exists, err := s.Load()
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, int64(5), cp.Marker)
+
+	cps, err := s.History()
+	assert.NoError(t, err)
+	assert.Equal(t, history, len(cps))
+	assert.Equal(t, int64(5), cps[len(cps)-1].Marker)
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	testCheckpointStore(t, NewFileCheckpointStore(filepath.Join(t.TempDir(), "marker.txt")), 1)
+}
+
+func TestJSONCheckpointStore(t *testing.T) {
+	s := NewJSONCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	s.MaxHistory = 3
+	testCheckpointStore(t, s, 3)
+
+	cp, _, err := s.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), cp.Processed)
+}
+
+func TestProducerConsumerRunner_Run_CheckpointStore(t *testing.T) {
+	s := NewJSONCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	consumer := &flakyConsumer{failTimes: 1, err: errTransient, attempts: map[int64]int{}}
+	cfg := ProducerConsumerConfig{
+		Produce:    &limitProducer{limit: 20},
+		Consume:    consumer,
+		Num:        1,
+		Checkpoint: s,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	p.Run()
+
+	cp, exists, err := s.Load()
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, int64(1), cp.Marker)
+	assert.Equal(t, int64(0), cp.Processed)
+	assert.Equal(t, errTransient.Error(), cp.Err)
+}
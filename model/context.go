@@ -0,0 +1,62 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrAborted is the cause of the ctx when a run is stopped by Abort
+var ErrAborted = errors.New("run aborted")
+
+// CancelPolicy decides how the entries left in buffer are handled when the ctx of RunContext is done
+type CancelPolicy int
+
+const (
+	// CancelDrain 停止 produce，consumer 处理完缓冲区中剩余的 entry 后退出（默认）
+	CancelDrain CancelPolicy = iota
+	// CancelAbandon 停止 produce，consumer 处理完当前的 entry 后立即退出，丢弃缓冲区中剩余的 entry
+	CancelAbandon
+)
+
+// abandonDone returns ctx.Done() if consumers should exit as soon as ctx is done, otherwise abort
+func abandonDone(ctx context.Context, policy CancelPolicy, abort <-chan struct{}) <-chan struct{} {
+	if policy == CancelAbandon {
+		return ctx.Done()
+	}
+	return abort
+}
+
+// withAbort returns a ctx canceled when abort is closed
+func withAbort(ctx context.Context, abort <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// stopCause returns ErrAborted if abort is closed, 否则返回 context.Cause(ctx)
+func stopCause(ctx context.Context, abort <-chan struct{}) error {
+	if isDone(abort) {
+		return ErrAborted
+	}
+	return context.Cause(ctx)
+}
+
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func canceledError(err error, processed int64) error {
+	return fmt.Errorf("run canceled, %d entries processed: %w", processed, err)
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+// 无限 produce，直到被停止
+type infiniteProducer struct {
+	id int64
+}
+
+func (p *infiniteProducer) Produce() (E, error) {
+	p.id++
+	return simpleEntry{id: p.id}, nil
+}
+
+// consume 到第 cancelAt 个时取消 ctx
+type cancelConsumer struct {
+	consumed int64
+	cancelAt int64
+	cancel   context.CancelFunc
+	sleep    time.Duration
+}
+
+func (c *cancelConsumer) Consume(entry E) error {
+	if atomic.AddInt64(&c.consumed, 1) == c.cancelAt {
+		c.cancel()
+	}
+	time.Sleep(c.sleep)
+	return nil
+}
+
+func TestProducerConsumerRunner_RunContext(t *testing.T) {
+	for _, policy := range []CancelPolicy{CancelDrain, CancelAbandon} {
+		ctx, cancel := context.WithCancel(context.Background())
+		path := filepath.Join(t.TempDir(), "marker.txt")
+		consumer := &cancelConsumer{cancelAt: 10, cancel: cancel, sleep: time.Millisecond}
+		cfg := ProducerConsumerConfig{
+			Produce:        &infiniteProducer{},
+			Consume:        consumer,
+			Num:            4,
+			MarkerFilePath: path,
+			CancelPolicy:   policy,
+		}
+		p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+		assert.NoError(t, err)
+
+		err = p.RunContext(ctx)
+		assert.True(t, errors.Is(err, context.Canceled), err)
+
+		marker, _, err := LoadMarker(path)
+		assert.NoError(t, err)
+		// marker 之前的 entry 都已经处理过
+		assert.True(t, marker > 0 && marker <= atomic.LoadInt64(&consumer.consumed)+1, policy, marker, consumer.consumed)
+	}
+}
+
+func TestProducerConsumer_RunContext(t *testing.T) {
+	var produced, consumed int64
+	produce := func() (Entry, error) {
+		return atomic.AddInt64(&produced, 1), nil
+	}
+	consume := func(Entry) error {
+		atomic.AddInt64(&consumed, 1)
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+
+	// drain：停止后处理完所有已经 produce 的 entry
+	p, err := NewProducerConsumer(produce, consume, 4)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = p.RunContext(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Equal(t, 0, p.BufferStatus())
+	// 最后一个 produce 的 entry 因为 ctx 结束没有放入缓冲区
+	assert.Equal(t, atomic.LoadInt64(&produced)-1, atomic.LoadInt64(&consumed))
+
+	// abandon：缓冲区中剩余的 entry 不再处理
+	atomic.StoreInt64(&produced, 0)
+	atomic.StoreInt64(&consumed, 0)
+	p, err = NewProducerConsumer(produce, consume, 4, WithCancelPolicy(CancelAbandon))
+	assert.NoError(t, err)
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	err = p.RunContext(ctx2)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.True(t, atomic.LoadInt64(&consumed) < atomic.LoadInt64(&produced))
+}
+
+func TestProducerConsumer_RunContext_Finished(t *testing.T) {
+	var n int64
+	produce := func() (Entry, error) {
+		if n++; n > 10 {
+			return nil, ErrFinished
+		}
+		return n, nil
+	}
+	p, err := NewProducerConsumer(produce, func(Entry) error { return nil }, 2)
+	assert.NoError(t, err)
+	assert.NoError(t, p.RunContext(context.Background()))
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// CursorEntry is an entry whose position is an opaque string cursor (比如对象存储 list 的 marker、数据库 keyset 分页的游标),
+// 而不是可以比较大小的 int64。
+// 续处理的位置由 produce 的顺序决定：第一个没有处理完成的 entry 的 Cursor，见 WatermarkTracker.Cursor。
+type CursorEntry interface {
+	String() string
+	Cursor() string     // 从这个 cursor 开始可以重新 produce 这个 entry
+	NextCursor() string // 从这个 cursor 开始可以 produce 下一个 entry
+}
+
+// CursorProducer Produce CursorEntry
+type CursorProducer interface {
+	Produce() (CursorEntry, error)
+}
+
+// CursorConsumer consume CursorEntry
+type CursorConsumer interface {
+	Consume(CursorEntry) error
+}
+
+// cursorE adapts CursorEntry to E, Marker 是 produce 的序号（从 1 开始）
+type cursorE struct {
+	CursorEntry
+	seq int64
+}
+
+func (e cursorE) Marker() int64 {
+	return e.seq
+}
+
+func (e cursorE) NextMarker() int64 {
+	return e.seq + 1
+}
+
+type cursorProducer struct {
+	p   CursorProducer
+	seq int64
+}
+
+func (p *cursorProducer) Produce() (E, error) {
+	entry, err := p.p.Produce()
+	if err != nil {
+		return nil, err
+	}
+	return cursorE{CursorEntry: entry, seq: atomic.AddInt64(&p.seq, 1)}, nil
+}
+
+type cursorConsumer struct {
+	c CursorConsumer
+}
+
+func (c cursorConsumer) Consume(entry E) error {
+	e, ok := entry.(cursorE)
+	if !ok {
+		return errors.New("entry is not produced by a CursorProducer")
+	}
+	return c.c.Consume(e.CursorEntry)
+}
+
+// AdaptCursorProducer adapts a CursorProducer to Producer, 用于 ProducerConsumerConfig.Produce。
+// 保存的 Checkpoint.Cursor 是续处理的位置（HasCursor 为 true，Cursor 为空表示从头开始），Checkpoint.Marker 只是 produce 的序号。
+// 最后一个 entry 的 NextCursor 为空表示到达末尾，全部处理完成后保存的 Checkpoint.Exhausted 为 true，不需要续处理。
+func AdaptCursorProducer(p CursorProducer) Producer {
+	return &cursorProducer{p: p}
+}
+
+// AdaptCursorConsumer adapts a CursorConsumer to Consumer, 需要和 AdaptCursorProducer 一起使用
+func AdaptCursorConsumer(c CursorConsumer) Consumer {
+	return cursorConsumer{c: c}
+}
@@ -0,0 +1,193 @@
+package model
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+// 使用不可比较大小的字符串作为游标
+type tokenEntry struct {
+	i    int
+	last bool
+}
+
+func (e tokenEntry) String() string {
+	return strconv.Itoa(e.i)
+}
+
+// 第一页的 cursor 为空，表示从头开始
+func (e tokenEntry) Cursor() string {
+	if e.i == 0 {
+		return ""
+	}
+	return token(e.i)
+}
+
+// 最后一页的 next cursor 为空，表示到达末尾
+func (e tokenEntry) NextCursor() string {
+	if e.last {
+		return ""
+	}
+	return token(e.i + 1)
+}
+
+func token(i int) string {
+	return "tok-" + strconv.FormatInt(int64(i*7919%1000), 36)
+}
+
+type tokenProducer struct {
+	i, limit int
+}
+
+// newTokenProducer 从 cursor 开始 produce
+func newTokenProducer(cursor string, limit int) *tokenProducer {
+	p := &tokenProducer{limit: limit}
+	for cursor != "" && token(p.i) != cursor {
+		p.i++
+	}
+	return p
+}
+
+func (p *tokenProducer) Produce() (CursorEntry, error) {
+	if p.i >= p.limit {
+		return nil, ErrFinished
+	}
+	e := tokenEntry{i: p.i, last: p.i == p.limit-1}
+	p.i++
+	return e, nil
+}
+
+type tokenConsumer struct {
+	m        sync.Mutex
+	failAt   int
+	consumed []int
+}
+
+func (c *tokenConsumer) Consume(entry CursorEntry) error {
+	e := entry.(tokenEntry)
+	if e.i == c.failAt {
+		return errors.New("consume failed")
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.consumed = append(c.consumed, e.i)
+	return nil
+}
+
+func TestProducerConsumerRunner_Run_Cursor(t *testing.T) {
+	for _, store := range []CheckpointStore{
+		NewFileCheckpointStore(filepath.Join(t.TempDir(), "marker.txt")),
+		NewJSONCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json")),
+	} {
+		consumer := &tokenConsumer{failAt: 13}
+		cfg := ProducerConsumerConfig{
+			Produce:    AdaptCursorProducer(newTokenProducer("", 30)),
+			Consume:    AdaptCursorConsumer(consumer),
+			Num:        1,
+			Checkpoint: store,
+		}
+		p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+		assert.NoError(t, err)
+		p.Run()
+		assert.Equal(t, 13, len(consumer.consumed))
+		assert.Equal(t, token(13), p.cursor)
+
+		cp, exists, err := store.Load()
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, token(13), cp.Cursor)
+
+		// 从保存的 cursor 续处理
+		consumer2 := &tokenConsumer{failAt: -1}
+		cfg.Produce = AdaptCursorProducer(newTokenProducer(cp.Cursor, 30))
+		cfg.Consume = AdaptCursorConsumer(consumer2)
+		cfg.Num = 3
+		cfg.CheckpointInterval = time.Hour
+		p2, err := NewProducerConsumerRunner(xlog.New(), cfg)
+		assert.NoError(t, err)
+		p2.Run()
+		assert.Equal(t, 17, len(consumer2.consumed))
+		assert.Equal(t, "", p2.Cursor())
+
+		// 处理完成后保存的空 cursor 表示到达末尾，而不是从头开始
+		cp, exists, err = store.Load()
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.True(t, cp.HasCursor)
+		assert.True(t, cp.Exhausted)
+		assert.Equal(t, "", cp.Cursor)
+	}
+}
+
+func TestProducerConsumerRunner_Run_CursorFirstFailed(t *testing.T) {
+	for _, store := range []CheckpointStore{
+		NewFileCheckpointStore(filepath.Join(t.TempDir(), "marker.txt")),
+		NewJSONCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json")),
+	} {
+		consumer := &tokenConsumer{failAt: 0}
+		cfg := ProducerConsumerConfig{
+			Produce:    AdaptCursorProducer(newTokenProducer("", 30)),
+			Consume:    AdaptCursorConsumer(consumer),
+			Num:        1,
+			Checkpoint: store,
+		}
+		p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+		assert.NoError(t, err)
+		p.Run()
+		assert.Equal(t, 0, len(consumer.consumed))
+
+		// 第一页失败，保存的是空的 cursor，而不是 produce 的序号
+		cp, exists, err := store.Load()
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.True(t, cp.HasCursor)
+		assert.False(t, cp.Exhausted)
+		assert.Equal(t, "", cp.Cursor)
+
+		consumer2 := &tokenConsumer{failAt: -1}
+		cfg.Produce = AdaptCursorProducer(newTokenProducer(cp.Cursor, 30))
+		cfg.Consume = AdaptCursorConsumer(consumer2)
+		p2, err := NewProducerConsumerRunner(xlog.New(), cfg)
+		assert.NoError(t, err)
+		p2.Run()
+		assert.Equal(t, 30, len(consumer2.consumed))
+	}
+}
+
+func TestFileCheckpointStore_Cursor(t *testing.T) {
+	s := NewFileCheckpointStore(filepath.Join(t.TempDir(), "marker.txt"))
+
+	// 纯数字的 cursor 不会被当作 marker
+	assert.NoError(t, s.Save(Checkpoint{Marker: 3, Cursor: "123", HasCursor: true}))
+	cp, _, err := s.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, Checkpoint{Cursor: "123", HasCursor: true}, cp)
+
+	assert.NoError(t, s.Save(Checkpoint{Marker: 3, HasCursor: true}))
+	cp, _, err = s.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, Checkpoint{HasCursor: true}, cp)
+
+	assert.NoError(t, s.Save(Checkpoint{Marker: 31, HasCursor: true, Exhausted: true}))
+	cp, _, err = s.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, Checkpoint{HasCursor: true, Exhausted: true}, cp)
+
+	// int64 的 marker 不会被当作 cursor
+	assert.NoError(t, s.Save(Checkpoint{Marker: 5}))
+	cp, _, err = s.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, Checkpoint{Marker: 5}, cp)
+}
+
+func TestAdaptCursorConsumer_InvalidEntry(t *testing.T) {
+	err := AdaptCursorConsumer(&tokenConsumer{}).Consume(simpleEntry{id: 1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wanfadong/go-utils"
+)
+
+// DeadLetter is an entry that failed to consume and was skipped
+type DeadLetter struct {
+	Entry    Entry
+	Desc     string // entry.String()，Entry 没有实现 String() 时使用 fmt.Sprint
+	Err      error
+	Attempts int // 尝试 consume 的次数
+}
+
+// DeadLetterSink receives entries that failed to consume
+type DeadLetterSink interface {
+	Put(dl DeadLetter) error
+}
+
+// DeadLetterConfig 配置 consume 失败时跳过 entry 并放入 Sink，而不是停止整个任务。
+// 死信数量超过阈值后，仍然按照原来的方式 fail fast。
+type DeadLetterConfig struct {
+	Sink     DeadLetterSink // 为 nil 时不启用
+	MaxCount int64          // 死信数量超过 MaxCount 后 fail fast，<= 0 表示不限制
+	MaxRatio float64        // 死信占处理总数的比例超过 MaxRatio 后 fail fast，<= 0 表示不限制
+	MinTotal int64          // 处理总数达到 MinTotal 后才检查 MaxRatio，避免刚开始时比例波动太大
+}
+
+// deadLetterHandler puts dead letters into sink and checks the threshold, it can be used concurrently
+type deadLetterHandler struct {
+	cfg      DeadLetterConfig
+	count    int64 // atomic 访问
+	accepted int64 // 成功放入 sink 的数量，atomic 访问
+}
+
+func newDeadLetterHandler(cfg DeadLetterConfig) *deadLetterHandler {
+	return &deadLetterHandler{cfg: cfg}
+}
+
+// Count returns the number of dead letters
+func (h *deadLetterHandler) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// Accepted returns the number of dead letters put into sink successfully
+func (h *deadLetterHandler) Accepted() int64 {
+	if h == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&h.accepted)
+}
+
+// handle returns nil if the entry is skipped and the run can continue, otherwise returns the error to fail fast.
+// processed 是 consume 成功的数量
+func (h *deadLetterHandler) handle(entry Entry, err error, attempts int, processed int64) error {
+	if h == nil || h.cfg.Sink == nil {
+		return err
+	}
+
+	count := atomic.AddInt64(&h.count, 1)
+	if h.cfg.MaxCount > 0 && count > h.cfg.MaxCount {
+		return fmt.Errorf("too many dead letters, count: %d: %w", count, err)
+	}
+	total := processed + count
+	if h.cfg.MaxRatio > 0 && total >= h.cfg.MinTotal && float64(count)/float64(total) > h.cfg.MaxRatio {
+		return fmt.Errorf("too many dead letters, count: %d, total: %d: %w", count, total, err)
+	}
+
+	dl := DeadLetter{
+		Entry:    entry,
+		Desc:     fmt.Sprint(entry),
+		Err:      err,
+		Attempts: attempts,
+	}
+	putErr := protect(entry, func() error {
+		return h.cfg.Sink.Put(dl)
+	})
+	if putErr != nil {
+		return fmt.Errorf("put dead letter failed: %v: %w", putErr, err)
+	}
+	atomic.AddInt64(&h.accepted, 1)
+	return nil
+}
+
+// FileDeadLetterSink writes dead letters to a file in JSON lines format
+type FileDeadLetterSink struct {
+	m    sync.Mutex
+	file *os.File
+}
+
+type deadLetterRecord struct {
+	Entry    string `json:"entry"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+	Time     string `json:"time"`
+}
+
+// NewFileDeadLetterSink opens or creates filename, dead letters are appended to it
+func NewFileDeadLetterSink(filename string) (s *FileDeadLetterSink, err error) {
+	file, _, err := go_utils.OpenOrCreateFile(filename)
+	if err != nil {
+		return
+	}
+	s = &FileDeadLetterSink{file: file}
+	return
+}
+
+// Put implements DeadLetterSink
+func (s *FileDeadLetterSink) Put(dl DeadLetter) error {
+	record := deadLetterRecord{
+		Entry:    dl.Desc,
+		Attempts: dl.Attempts,
+		Time:     go_utils.FormatTime(time.Now()),
+	}
+	if dl.Err != nil {
+		record.Error = dl.Err.Error()
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	_, err = s.file.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the file
+func (s *FileDeadLetterSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+var errPoisoned = errors.New("poisoned")
+
+// id 是 3 的倍数的 entry 总是 consume 失败
+type poisonedConsumer struct {
+	countConsumer
+}
+
+func (c *poisonedConsumer) Consume(entry E) error {
+	if entry.Marker()%3 == 0 {
+		return errPoisoned
+	}
+	return c.countConsumer.Consume(entry)
+}
+
+func TestProducerConsumerRunner_Run_DeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead", "letters.jsonl")
+	sink, err := NewFileDeadLetterSink(path)
+	assert.NoError(t, err)
+
+	consumer := &poisonedConsumer{}
+	cfg := ProducerConsumerConfig{
+		Produce:    &limitProducer{limit: 30},
+		Consume:    consumer,
+		Num:        2,
+		DeadLetter: DeadLetterConfig{Sink: sink},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	p.Run()
+	assert.NoError(t, sink.Close())
+
+	assert.Equal(t, int64(20), consumer.consumed)
+	assert.Equal(t, int64(10), p.deadLetter.Count())
+	assert.Equal(t, int64(0), p.marker)
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lines := 0
+	for scanner.Scan() {
+		var record deadLetterRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		assert.Equal(t, errPoisoned.Error(), record.Error)
+		assert.Equal(t, 1, record.Attempts)
+		lines++
+	}
+	assert.Equal(t, 10, lines)
+}
+
+type memDeadLetterSink struct {
+	m       sync.Mutex
+	letters []DeadLetter
+}
+
+func (s *memDeadLetterSink) Put(dl DeadLetter) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.letters = append(s.letters, dl)
+	return nil
+}
+
+func TestProducerConsumerRunner_Run_DeadLetterMaxCount(t *testing.T) {
+	sink := &memDeadLetterSink{}
+	cfg := ProducerConsumerConfig{
+		Produce:    &limitProducer{limit: 30},
+		Consume:    &poisonedConsumer{},
+		Num:        1,
+		DeadLetter: DeadLetterConfig{Sink: sink, MaxCount: 2},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	p.Run()
+
+	assert.Equal(t, 2, len(sink.letters))
+	assert.Equal(t, "3", sink.letters[0].Desc)
+	// 第三个死信超过阈值，fail fast
+	assert.Equal(t, int64(9), p.marker)
+}
+
+func TestProducerConsumer_Run_DeadLetterMaxRatio(t *testing.T) {
+	var n int64
+	produce := func() (Entry, error) {
+		if n++; n > 100 {
+			return nil, ErrFinished
+		}
+		return n, nil
+	}
+	consume := func(e Entry) error {
+		if e.(int64) > 50 {
+			return errPoisoned
+		}
+		return nil
+	}
+
+	sink := &memDeadLetterSink{}
+	p, err := NewProducerConsumer(produce, consume, 1, WithDeadLetter(DeadLetterConfig{Sink: sink, MaxRatio: 0.1, MinTotal: 10}))
+	assert.NoError(t, err)
+	err = p.Run()
+	assert.True(t, errors.Is(err, errPoisoned), err)
+	// 50 个成功之后，第 6 个死信时比例超过 10%
+	assert.Equal(t, 5, len(sink.letters))
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"sync/atomic"
+
+	"github.com/wanfadong/go-utils/tool"
+)
+
+// runMetrics 是两种 runner 共有的 metrics
+type runMetrics struct {
+	name      string
+	produced  int64
+	consumed  int64
+	failed    int64
+	dead      int64
+	buffered  int
+	capacity  int
+	consumers int
+	paused    bool
+}
+
+func (m runMetrics) metrics() []tool.Metric {
+	paused := 0.0
+	if m.paused {
+		paused = 1
+	}
+	metrics := []tool.Metric{
+		{Name: "producer_consumer_produced_total", Help: "Number of entries produced.", Type: tool.MetricCounter, Value: float64(m.produced)},
+		{Name: "producer_consumer_processed_total", Help: "Number of entries consumed successfully.", Type: tool.MetricCounter, Value: float64(m.consumed)},
+		{Name: "producer_consumer_failed_total", Help: "Number of entries failed after retries, including dead letters.", Type: tool.MetricCounter, Value: float64(m.failed)},
+		{Name: "producer_consumer_dead_letters_total", Help: "Number of entries put into dead letter sink.", Type: tool.MetricCounter, Value: float64(m.dead)},
+		{Name: "producer_consumer_buffer_entries", Help: "Number of entries in buffer.", Type: tool.MetricGauge, Value: float64(m.buffered)},
+		{Name: "producer_consumer_buffer_capacity", Help: "Capacity of buffer.", Type: tool.MetricGauge, Value: float64(m.capacity)},
+		{Name: "producer_consumer_active_consumers", Help: "Number of active consumers.", Type: tool.MetricGauge, Value: float64(m.consumers)},
+		{Name: "producer_consumer_paused", Help: "1 if paused.", Type: tool.MetricGauge, Value: paused},
+	}
+	return withName(metrics, m.name)
+}
+
+// withName 把 name 作为 label，name 为空时不加
+func withName(metrics []tool.Metric, name string) []tool.Metric {
+	if name == "" {
+		return metrics
+	}
+	labels := map[string]string{"name": name}
+	for i := range metrics {
+		metrics[i].Labels = labels
+	}
+	return metrics
+}
+
+func sumFailed(stats []consumerStats) (failed int64) {
+	for i := range stats {
+		failed += atomic.LoadInt64(&stats[i].failed)
+	}
+	return
+}
+
+// Metrics returns the metrics of the runner and its Speedometer, 见 tool.MetricsHandler
+func (p *ProducerConsumerRunner) Metrics() []tool.Metric {
+	metrics := runMetrics{
+		name:      p.name,
+		produced:  atomic.LoadInt64(&p.produced),
+		consumed:  atomic.LoadInt64(&p.processed),
+		failed:    sumFailed(p.stats),
+		dead:      p.deadLetter.Accepted(),
+		buffered:  p.BufferStatus(),
+		capacity:  cap(p.buf),
+		consumers: p.Workers(),
+		paused:    p.pauser.Paused(),
+	}.metrics()
+	metrics = append(metrics, withName([]tool.Metric{
+		{Name: "producer_consumer_marker", Help: "Marker of the first entry not completed.", Type: tool.MetricGauge, Value: float64(p.tracker.Watermark())},
+	}, p.name)...)
+	// retries、ETA 等由 Speedometer 提供
+	return append(metrics, p.speedCounter.Metrics()...)
+}
+
+// Metrics returns the metrics of the Pipeline, 见 tool.MetricsHandler
+func (p *Pipeline[T]) Metrics() []tool.Metric {
+	return runMetrics{
+		name:      p.name,
+		produced:  atomic.LoadInt64(&p.produced),
+		consumed:  atomic.LoadInt64(&p.processed),
+		failed:    sumFailed(p.stats),
+		dead:      p.deadLetter.Accepted(),
+		buffered:  p.BufferStatus(),
+		capacity:  cap(p.buf),
+		consumers: p.Workers(),
+		paused:    p.pauser.Paused(),
+	}.metrics()
+}
+
+// Metrics returns the metrics of the ProducerConsumer, 见 tool.MetricsHandler
+func (p *ProducerConsumer) Metrics() []tool.Metric {
+	return p.p.Metrics()
+}
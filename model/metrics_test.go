@@ -0,0 +1,88 @@
+package model
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+	"github.com/wanfadong/go-utils/tool"
+)
+
+func scrape(t *testing.T, sources ...tool.MetricsSource) string {
+	rec := httptest.NewRecorder()
+	tool.MetricsHandler(sources...).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	return rec.Body.String()
+}
+
+func assertMetrics(t *testing.T, body string, lines ...string) {
+	for _, line := range lines {
+		assert.True(t, strings.Contains(body, line+"\n"), line, body)
+	}
+}
+
+func TestProducerConsumerRunner_Metrics(t *testing.T) {
+	consumer := newGateConsumer()
+	cfg := ProducerConsumerConfig{
+		Produce: &infiniteProducer{},
+		Consume: consumer,
+		Num:     2,
+		ScCfg:   tool.SpeedometerConfig{Name: "backfill"},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Run()
+	}()
+
+	// consumer 都被阻塞，等待缓冲区满
+	<-consumer.started
+	<-consumer.started
+	for p.BufferStatus() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Pause()
+	body := scrape(t, p)
+	assertMetrics(t, body,
+		"# TYPE producer_consumer_processed_total counter",
+		`producer_consumer_processed_total{name="backfill"} 0`,
+		`producer_consumer_buffer_entries{name="backfill"} 2`,
+		`producer_consumer_buffer_capacity{name="backfill"} 2`,
+		`producer_consumer_active_consumers{name="backfill"} 2`,
+		`producer_consumer_paused{name="backfill"} 1`,
+		`producer_consumer_marker{name="backfill"} 1`,
+		`speedometer_retries_total{name="backfill"} 0`,
+	)
+
+	p.Resume()
+	p.Abort()
+	close(consumer.release)
+	<-errCh
+	body = scrape(t, p)
+	assertMetrics(t, body,
+		`producer_consumer_processed_total{name="backfill"} 2`,
+		`producer_consumer_paused{name="backfill"} 0`,
+		`producer_consumer_marker{name="backfill"} 3`,
+	)
+}
+
+func TestPipeline_Metrics(t *testing.T) {
+	sink := &memDeadLetterSink{}
+	p, err := NewEPipeline(&limitProducer{limit: 30}, &poisonedConsumer{}, 2, WithDeadLetter(DeadLetterConfig{Sink: sink}))
+	assert.NoError(t, err)
+	assert.NoError(t, p.Run())
+
+	body := scrape(t, p)
+	assertMetrics(t, body,
+		`producer_consumer_produced_total{name="Pipeline"} 30`,
+		`producer_consumer_processed_total{name="Pipeline"} 20`,
+		`producer_consumer_failed_total{name="Pipeline"} 10`,
+		`producer_consumer_dead_letters_total{name="Pipeline"} 10`,
+		`producer_consumer_buffer_entries{name="Pipeline"} 0`,
+	)
+	assert.False(t, strings.Contains(body, "speedometer_"))
+}
@@ -0,0 +1,60 @@
+package model
+
+// Transformer transforms E into a result, 用于有序输出模式，见 ProducerConsumerConfig.Sink
+type Transformer interface {
+	Transform(E) (interface{}, error)
+}
+
+// Sink receives results of Transformer in produce order
+type Sink interface {
+	Emit(entry E, result interface{}) error
+}
+
+// orderedResult is a result waiting to be emitted by sink
+type orderedResult struct {
+	trackedEntry
+	result interface{}
+	skip   bool // 放入死信的 entry 没有结果，只需要占住它的顺序
+}
+
+// doSink 按照 produce 的顺序（WatermarkTracker 的序号）把 consumer 的结果交给 sink。
+// 还不能 Emit 的结果暂存在 pending 中，数量受 reorder window 限制。
+func (p *ProducerConsumerRunner) doSink() {
+	xl := p.xl
+
+	pending := make(map[int64]orderedResult)
+	var next int64
+	failed := false
+	for r := range p.results {
+		pending[r.seq] = r
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			if !r.skip && !failed {
+				err := protect(r.entry, func() error {
+					return p.sink.Emit(r.entry, r.result)
+				})
+				if err != nil {
+					xl.Infof("sink failed, entry: %v, err: %v", r.entry, err)
+					p.addConsumeErr(-1, r.entry, err)
+					p.setStopped(err)
+					safeClose(p.stop)
+					failed = true
+				}
+			}
+			// sink 出错之后的 entry 都没有完成，watermark 停在出错的 entry
+			if !failed {
+				p.tracker.Done(r.seq)
+			}
+			<-p.window
+		}
+	}
+	if len(pending) > 0 {
+		xl.Infof("sink exit with %v results not emitted, next: %v", len(pending), next)
+	}
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+// 随机耗时，结果乱序完成
+type sleepTransformer struct {
+	tracker *WatermarkTracker
+	maxPend int64
+}
+
+func (t *sleepTransformer) Transform(entry E) (interface{}, error) {
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	if t.tracker != nil {
+		if pending := int64(t.tracker.Pending()); pending > atomic.LoadInt64(&t.maxPend) {
+			atomic.StoreInt64(&t.maxPend, pending)
+		}
+	}
+	return entry.Marker() * 10, nil
+}
+
+type recordSink struct {
+	results []interface{}
+	failAt  int64
+}
+
+func (s *recordSink) Emit(entry E, result interface{}) error {
+	if entry.Marker() == s.failAt {
+		return errors.New("emit failed")
+	}
+	s.results = append(s.results, result)
+	return nil
+}
+
+func TestProducerConsumerRunner_Run_Ordered(t *testing.T) {
+	transformer := &sleepTransformer{}
+	sink := &recordSink{}
+	cfg := ProducerConsumerConfig{
+		Produce:       &limitProducer{limit: 100},
+		Num:           8,
+		Transform:     transformer,
+		Sink:          sink,
+		ReorderWindow: 4,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	transformer.tracker = p.tracker
+	p.Run()
+
+	assert.Equal(t, 100, len(sink.results))
+	for i, r := range sink.results {
+		assert.Equal(t, int64(i+1)*10, r)
+	}
+	// window 满了之后 producer 被阻塞（加上已经 produce 但是还没有占到位置的一个）
+	assert.True(t, transformer.maxPend <= 5, transformer.maxPend)
+	assert.Equal(t, int64(0), p.marker)
+}
+
+func TestProducerConsumerRunner_Run_OrderedSinkErr(t *testing.T) {
+	sink := &recordSink{failAt: 30}
+	cfg := ProducerConsumerConfig{
+		Produce:   &limitProducer{limit: 100},
+		Num:       4,
+		Transform: &sleepTransformer{},
+		Sink:      sink,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	p.Run()
+
+	assert.Equal(t, 29, len(sink.results))
+	assert.Equal(t, int64(30), p.marker)
+}
+
+func TestNewProducerConsumerRunner_OrderedWithoutTransform(t *testing.T) {
+	_, err := NewProducerConsumerRunner(xlog.New(), ProducerConsumerConfig{Num: 1, Sink: &recordSink{}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a panic recovered from Produce/Consume, 和普通的失败一样处理：记录 marker 并从 Run 返回。
+// RetryPolicy.Retryable、DeadLetterSink.Put、CheckpointStore.Save 等回调中的 panic 也会被恢复
+type PanicError struct {
+	Value interface{} // recover() 的返回值
+	Entry interface{} // 发生 panic 时正在处理的 entry，producer panic 时为 nil
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v, entry: %v\n%s", e.Value, e.Entry, e.Stack)
+}
+
+// Unwrap returns the panic value if it is an error
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// protect calls fn, 把 fn 中的 panic 转换为 *PanicError
+func protect(entry interface{}, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Entry: entry, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+type panicConsumer struct {
+	panicAt int64
+}
+
+func (c *panicConsumer) Consume(entry E) error {
+	if entry.Marker() == c.panicAt {
+		panic("consume panic")
+	}
+	return nil
+}
+
+type panicProducer struct {
+	limitProducer
+	panicAt int64
+}
+
+func (p *panicProducer) Produce() (E, error) {
+	if p.id+1 == p.panicAt {
+		var m map[string]int
+		m["x"] = 1 // nil map
+	}
+	return p.limitProducer.Produce()
+}
+
+func TestProducerConsumerRunner_Run_ConsumePanic(t *testing.T) {
+	cfg := ProducerConsumerConfig{
+		Produce: &limitProducer{limit: 100},
+		Consume: &panicConsumer{panicAt: 30},
+		Num:     1,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	err = p.Run()
+
+	var pe *PanicError
+	assert.True(t, errors.As(err, &pe))
+	assert.Equal(t, "consume panic", pe.Value)
+	assert.Equal(t, simpleEntry{id: 30}, pe.Entry)
+	assert.True(t, strings.Contains(string(pe.Stack), "panicConsumer"))
+	assert.Equal(t, int64(30), p.marker)
+}
+
+func TestProducerConsumerRunner_Run_ProducePanic(t *testing.T) {
+	cfg := ProducerConsumerConfig{
+		Produce: &panicProducer{limitProducer: limitProducer{limit: 100}, panicAt: 20},
+		Consume: &countConsumer{},
+		Num:     2,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	err = p.Run()
+
+	var pe *PanicError
+	assert.True(t, errors.As(err, &pe))
+	assert.Nil(t, pe.Entry)
+	// panic 的值是 runtime.Error
+	assert.NotNil(t, errors.Unwrap(pe))
+	assert.Equal(t, int64(20), p.marker)
+}
+
+func TestPipeline_Run_Panic(t *testing.T) {
+	p, err := NewEPipeline(&limitProducer{limit: 100}, &panicConsumer{panicAt: 10}, 4)
+	assert.NoError(t, err)
+	err = p.Run()
+
+	var pe *PanicError
+	assert.True(t, errors.As(err, &pe))
+	assert.Equal(t, simpleEntry{id: 10}, pe.Entry)
+
+	p, err = NewEPipeline(&panicProducer{limitProducer: limitProducer{limit: 100}, panicAt: 10}, &countConsumer{}, 4)
+	assert.NoError(t, err)
+	assert.True(t, errors.As(p.Run(), &pe))
+}
+
+type panicDeadLetterSink struct{}
+
+func (panicDeadLetterSink) Put(DeadLetter) error {
+	panic("put panic")
+}
+
+type panicCheckpointStore struct {
+	CheckpointStore
+}
+
+func (panicCheckpointStore) Save(Checkpoint) error {
+	panic("save panic")
+}
+
+// 用户提供的回调中的 panic 不会导致进程退出
+func TestProducerConsumerRunner_Run_CallbackPanic(t *testing.T) {
+	retryPanic := RetryPolicy{
+		MaxAttempts: 3,
+		Retryable: func(error) bool {
+			panic("retryable panic")
+		},
+	}
+	cfg := ProducerConsumerConfig{
+		Produce:     &limitProducer{limit: 100},
+		Consume:     &flakyConsumer{failTimes: 1, err: errTransient, attempts: map[int64]int{}},
+		Num:         1,
+		RetryPolicy: retryPanic,
+		Checkpoint:  panicCheckpointStore{},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	err = p.Run()
+	var pe *PanicError
+	assert.True(t, errors.As(err, &pe))
+	assert.Equal(t, "retryable panic", pe.Value)
+	assert.Equal(t, int64(1), p.marker)
+
+	// 批量消费时的 Retryable
+	cfg.Produce = &limitProducer{limit: 100}
+	cfg.Consume = nil
+	cfg.BatchConsume = &recordBatchConsumer{failAt: 1}
+	p, err = NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	assert.True(t, errors.As(p.Run(), &pe))
+	assert.Equal(t, int64(1), p.marker)
+
+	// DeadLetterSink.Put
+	cfg = ProducerConsumerConfig{
+		Produce:    &limitProducer{limit: 100},
+		Consume:    &panicConsumer{panicAt: 5},
+		Num:        1,
+		DeadLetter: DeadLetterConfig{Sink: panicDeadLetterSink{}},
+	}
+	p, err = NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	err = p.Run()
+	assert.True(t, strings.Contains(err.Error(), "put panic"), err)
+	assert.Equal(t, int64(5), p.marker)
+}
+
+func TestMultiStagePipeline_Run_Panic(t *testing.T) {
+	p, err := NewMultiStagePipeline(listProducer(10), Stage{
+		Name: "panic",
+		Num:  2,
+		Process: func(in Entry, emit func(Entry) error) error {
+			if in.(int) == 5 {
+				panic("process panic")
+			}
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	var pe *PanicError
+	assert.True(t, errors.As(p.Run(), &pe))
+	assert.Equal(t, 5, pe.Entry)
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"sync"
+)
+
+// RunState is the state of a runner
+type RunState string
+
+const (
+	StateIdle     RunState = "idle" // 还没有开始 Run
+	StateRunning  RunState = "running"
+	StatePaused   RunState = "paused"
+	StateFinished RunState = "finished" // Run 已经返回
+)
+
+// RunStatus is a snapshot of a runner, 可以在 Run 的过程中并发调用 Status 获取
+type RunStatus struct {
+	State    RunState `json:"state"`
+	Produced int64    `json:"produced"`
+	Consumed int64    `json:"consumed"`
+	Buffered int      `json:"buffered"` // 缓冲区中的 entry 数量
+	Workers  int      `json:"workers"`  // 活跃的 consumer 数量
+	Marker   int64    `json:"marker,omitempty"`
+	Cursor   string   `json:"cursor,omitempty"`
+}
+
+const (
+	runIdle int32 = iota
+	runRunning
+	runFinished
+)
+
+func runState(state int32, paused bool) RunState {
+	switch state {
+	case runIdle:
+		return StateIdle
+	case runFinished:
+		return StateFinished
+	}
+	if paused {
+		return StatePaused
+	}
+	return StateRunning
+}
+
+// closedChan 不暂停时 resumed 返回的 chan
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// pauser halts producer and consumers between entries, 暂停时缓冲区和 marker 保持不变
+type pauser struct {
+	m      sync.Mutex
+	resume chan struct{} // 暂停时不为 nil，Resume 时关闭
+}
+
+// Pause returns false if it is already paused
+func (p *pauser) Pause() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.resume != nil {
+		return false
+	}
+	p.resume = make(chan struct{})
+	return true
+}
+
+// Resume returns false if it is not paused
+func (p *pauser) Resume() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.resume == nil {
+		return false
+	}
+	close(p.resume)
+	p.resume = nil
+	return true
+}
+
+func (p *pauser) Paused() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.resume != nil
+}
+
+// resumed returns a chan closed when not paused
+func (p *pauser) resumed() <-chan struct{} {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.resume == nil {
+		return closedChan
+	}
+	return p.resume
+}
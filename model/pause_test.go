@@ -0,0 +1,142 @@
+package model
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+func waitConsumed(t *testing.T, status func() RunStatus, n int64) {
+	for i := 0; i < 1000; i++ {
+		if status().Consumed >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("consumed less than %v", n)
+}
+
+func TestProducerConsumerRunner_Pause(t *testing.T) {
+	cfg := ProducerConsumerConfig{
+		Produce: &limitProducer{limit: 200},
+		Consume: &slowConsumer{sleep: time.Millisecond},
+		Num:     2,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, StateIdle, p.Status().State)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Run()
+	}()
+	waitConsumed(t, p.Status, 10)
+	p.Pause()
+	// 等待正在处理的 entry 完成
+	time.Sleep(20 * time.Millisecond)
+	paused := p.Status()
+	assert.Equal(t, StatePaused, paused.State)
+	assert.True(t, p.speedCounter.Paused())
+
+	time.Sleep(30 * time.Millisecond)
+	status := p.Status()
+	assert.Equal(t, paused, status)
+	// 缓冲区和 marker 保持不变
+	assert.True(t, status.Buffered <= 2)
+	assert.Equal(t, status.Consumed+1, status.Marker)
+
+	p.Resume()
+	assert.False(t, p.speedCounter.Paused())
+	assert.NoError(t, <-errCh)
+	status = p.Status()
+	assert.Equal(t, StateFinished, status.State)
+	assert.Equal(t, int64(200), status.Consumed)
+}
+
+// stepProducer 在 produce 第二个 entry 之前等待 step 被关闭
+type stepProducer struct {
+	limitProducer
+	step chan struct{}
+}
+
+func (p *stepProducer) Produce() (E, error) {
+	if p.id == 1 {
+		<-p.step
+	}
+	return p.limitProducer.Produce()
+}
+
+type failGateConsumer struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *failGateConsumer) Consume(E) error {
+	close(c.started)
+	<-c.release
+	return errors.New("consume failed")
+}
+
+func TestProducerConsumerRunner_Pause_ConsumeErr(t *testing.T) {
+	producer := &stepProducer{limitProducer: limitProducer{limit: 100}, step: make(chan struct{})}
+	consumer := &failGateConsumer{started: make(chan struct{}), release: make(chan struct{})}
+	cfg := ProducerConsumerConfig{
+		Produce: producer,
+		Consume: consumer,
+		Num:     1,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Run()
+	}()
+	<-consumer.started
+	// producer 放入第二个 entry 后暂停
+	p.Pause()
+	close(producer.step)
+	time.Sleep(20 * time.Millisecond)
+
+	// 暂停时正在处理的 entry 失败，不需要 Resume 也会退出
+	close(consumer.release)
+	select {
+	case err := <-errCh:
+		assert.Error(t, err)
+		assert.Equal(t, int64(1), p.marker)
+	case <-time.After(time.Second):
+		t.Fatal("run is stuck while paused")
+	}
+}
+
+func TestProducerConsumer_Pause(t *testing.T) {
+	var consumed int64
+	p, err := NewProducerConsumer(listProducer(200), func(Entry) error {
+		atomic.AddInt64(&consumed, 1)
+		time.Sleep(time.Millisecond)
+		return nil
+	}, 2)
+	assert.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Run()
+	}()
+	waitConsumed(t, p.Status, 10)
+	p.Pause()
+	time.Sleep(20 * time.Millisecond)
+	paused := p.Status()
+	assert.Equal(t, StatePaused, paused.State)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, paused, p.Status())
+	assert.Equal(t, paused.Consumed, atomic.LoadInt64(&consumed))
+
+	p.Resume()
+	assert.NoError(t, <-errCh)
+	assert.Equal(t, int64(200), atomic.LoadInt64(&consumed))
+	assert.Equal(t, StateFinished, p.Status().State)
+}
@@ -0,0 +1,399 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wanfadong/go-utils"
+)
+
+// Pipeline is a typed producer-consumer model, it has the same semantics as ProducerConsumer:
+// produce 返回 ErrFinished 表示正常结束；任意一个 producer/consumer 出错，都会通过 fail chan 让其他 goroutine 尽快退出。
+type Pipeline[T any] struct {
+	l *logrus.Entry
+
+	produceFunc func() (T, error)
+	consumeFunc func(T) error
+	consumerNum int
+	cfg         pipelineConfig
+	deadLetter  *deadLetterHandler
+	limiters    *rateLimiters
+	ctrl        *concurrencyController // 为 nil 时 consumer 数量固定
+
+	buf      chan T
+	fail     chan struct{}
+	finished chan struct{} // buf 被关闭并且取空后关闭，通知不活跃的 worker 退出
+	abort    chan struct{} // Abort 之后关闭
+
+	processed int64 // consume 成功的数量，atomic 访问
+	canceled  int32 // 是否因为 ctx 结束而退出，atomic 访问
+
+	name      string
+	startTime time.Time
+	produced  int64           // atomic 访问
+	stats     []consumerStats // 每个 consumer 的统计
+	state     int32           // runIdle/runRunning/runFinished，atomic 访问
+	pauser    pauser
+}
+
+// pipelineConfig is the optional settings shared by Pipeline and ProducerConsumer
+type pipelineConfig struct {
+	cancelPolicy CancelPolicy
+	deadLetter   DeadLetterConfig
+	rateLimit    RateLimitConfig
+	adaptive     *AdaptiveConcurrency
+}
+
+// ProducerConsumerOption is an optional setting of ProducerConsumer and Pipeline
+type ProducerConsumerOption func(cfg *pipelineConfig)
+
+// WithCancelPolicy sets how RunContext handles the entries left in buffer when ctx is done
+func WithCancelPolicy(policy CancelPolicy) ProducerConsumerOption {
+	return func(cfg *pipelineConfig) {
+		cfg.cancelPolicy = policy
+	}
+}
+
+// WithDeadLetter puts entries failed to consume into dead letter sink instead of stopping the run
+func WithDeadLetter(cfg DeadLetterConfig) ProducerConsumerOption {
+	return func(c *pipelineConfig) {
+		c.deadLetter = cfg
+	}
+}
+
+// WithRateLimit limits how fast entries are produced and consumed
+func WithRateLimit(cfg RateLimitConfig) ProducerConsumerOption {
+	return func(c *pipelineConfig) {
+		c.rateLimit = cfg
+	}
+}
+
+// WithAdaptiveConcurrency adjusts the number of active consumers based on latency and error rate
+func WithAdaptiveConcurrency(cfg AdaptiveConcurrency) ProducerConsumerOption {
+	return func(c *pipelineConfig) {
+		c.adaptive = &cfg
+	}
+}
+
+// NewPipeline return a Pipeline with given produce & consume func and consumer num
+func NewPipeline[T any](produce func() (T, error), consume func(T) error, num int, opts ...ProducerConsumerOption) (p *Pipeline[T], err error) {
+	return newPipeline("Pipeline", produce, consume, num, opts...)
+}
+
+// NewEPipeline adapts existing Producer & Consumer implementations to a Pipeline
+func NewEPipeline(producer Producer, consumer Consumer, num int, opts ...ProducerConsumerOption) (p *Pipeline[E], err error) {
+	return NewPipeline(producer.Produce, consumer.Consume, num, opts...)
+}
+
+func newPipeline[T any](name string, produce func() (T, error), consume func(T) error, num int, opts ...ProducerConsumerOption) (p *Pipeline[T], err error) {
+
+	if num <= 0 {
+		err = errors.New("invalid consumer number")
+		return
+	}
+
+	p = &Pipeline[T]{
+		l:           logrus.WithField(go_utils.ReqidKey, name),
+		produceFunc: produce,
+		consumeFunc: consume,
+		consumerNum: num,
+
+		buf:      make(chan T, num),
+		fail:     make(chan struct{}),
+		finished: make(chan struct{}),
+		abort:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&p.cfg)
+	}
+	if p.cfg.adaptive != nil {
+		if err = p.cfg.adaptive.validate(); err != nil {
+			return nil, err
+		}
+		p.ctrl = newConcurrencyController(*p.cfg.adaptive, num)
+	}
+	p.deadLetter = newDeadLetterHandler(p.cfg.deadLetter)
+	p.limiters = newRateLimiters(p.cfg.rateLimit, p.workerNum())
+	p.name = name
+	p.stats = make([]consumerStats, p.workerNum())
+	return
+}
+
+// workerNum returns the number of consumer goroutines, 自适应时为 Max，其中只有一部分是活跃的
+func (p *Pipeline[T]) workerNum() int {
+	if p.ctrl != nil {
+		return p.ctrl.cfg.Max
+	}
+	return p.consumerNum
+}
+
+// Workers returns the number of active consumers
+func (p *Pipeline[T]) Workers() int {
+	if p.ctrl != nil {
+		return p.ctrl.Limit()
+	}
+	return p.consumerNum
+}
+
+// BufferStatus 查看缓冲区状态
+func (p *Pipeline[T]) BufferStatus() int {
+	return len(p.buf)
+}
+
+// Abort stops producing, consumers exit after the current entry and the entries left in buffer are abandoned
+func (p *Pipeline[T]) Abort() {
+	closeChanSafely(p.abort)
+}
+
+// Pause halts the producer and idles consumers after the current entry, 缓冲区保持不变
+func (p *Pipeline[T]) Pause() {
+	if p.pauser.Pause() {
+		p.l.Info("paused")
+	}
+}
+
+// Resume continues a paused run
+func (p *Pipeline[T]) Resume() {
+	if p.pauser.Resume() {
+		p.l.Info("resumed")
+	}
+}
+
+// Status returns the current status of the run
+func (p *Pipeline[T]) Status() RunStatus {
+	return RunStatus{
+		State:    runState(atomic.LoadInt32(&p.state), p.pauser.Paused()),
+		Produced: atomic.LoadInt64(&p.produced),
+		Consumed: atomic.LoadInt64(&p.processed),
+		Buffered: len(p.buf),
+		Workers:  p.Workers(),
+	}
+}
+
+// Run produce & consume until produce finished or any error occurs.
+// 出错时返回 *RunError，包含 producer 和所有失败的 consumer 的错误
+func (p *Pipeline[T]) Run() error {
+	return p.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops producing when ctx is done.
+// 缓冲区中剩余的 entry 按照 CancelPolicy 处理；因为 ctx 结束而退出时，返回包装了 ctx.Err() 的错误。
+func (p *Pipeline[T]) RunContext(ctx context.Context) error {
+	_, err := p.RunWithReport(ctx)
+	return err
+}
+
+// RunWithReport is like RunContext, and returns the statistics of the run
+func (p *Pipeline[T]) RunWithReport(ctx context.Context) (report RunReport, err error) {
+	p.startTime = time.Now()
+	atomic.StoreInt32(&p.state, runRunning)
+	defer atomic.StoreInt32(&p.state, runFinished)
+	ctx, cancel := withAbort(ctx, p.abort)
+	defer cancel()
+	workerNum := p.workerNum()
+	wg := sync.WaitGroup{}
+	wg.Add(1 + workerNum)
+
+	if p.ctrl != nil {
+		ctrlStop := make(chan struct{})
+		defer close(ctrlStop)
+		go p.ctrl.run(ctrlStop)
+	}
+
+	var produceErr error
+	go func() {
+		defer wg.Done()
+		produceErr = p.doProduce(ctx)
+	}()
+
+	consumeErrs := make([]*ConsumeError, workerNum)
+	for i := 0; i < workerNum; i++ {
+		go func(i int) {
+			defer wg.Done()
+			consumeErrs[i] = p.doConsume(ctx, i)
+		}(i)
+	}
+
+	wg.Wait()
+
+	var failed []*ConsumeError
+	for _, consumeErr := range consumeErrs {
+		if consumeErr != nil {
+			failed = append(failed, consumeErr)
+		}
+	}
+	var cancelErr error
+	if atomic.LoadInt32(&p.canceled) != 0 {
+		cancelErr = canceledError(stopCause(ctx, p.abort), atomic.LoadInt64(&p.processed))
+	}
+	err = runResult(produceErr, failed, cancelErr)
+	return p.report(produceErr, failed, err), err
+}
+
+func (p *Pipeline[T]) report(produceErr error, failed []*ConsumeError, err error) RunReport {
+	wall := time.Since(p.startTime)
+	report := RunReport{
+		Name:        p.name,
+		Produced:    atomic.LoadInt64(&p.produced),
+		Consumed:    atomic.LoadInt64(&p.processed),
+		DeadLetters: p.deadLetter.Accepted(),
+		StartTime:   p.startTime,
+		WallTime:    wall,
+		Throughput:  throughput(atomic.LoadInt64(&p.processed), wall),
+		Consumers:   consumerReports(p.stats, wall),
+		StopReason:  StopFinished,
+	}
+	for _, c := range report.Consumers {
+		report.Failed += c.Failed
+	}
+	switch {
+	case produceErr != nil:
+		report.StopReason = StopProduceFailed
+	case len(failed) > 0:
+		report.StopReason = StopConsumeFailed
+	case atomic.LoadInt32(&p.canceled) != 0:
+		report.StopReason = StopCanceled
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+func (p *Pipeline[T]) doProduce(ctx context.Context) error {
+	for {
+		// 暂停时等待 Resume
+		if resumed := p.pauser.resumed(); !isDone(resumed) {
+			select {
+			case <-resumed:
+			case <-ctx.Done():
+				p.l.Info("ctx done while paused, producer exit", ctx.Err())
+				atomic.StoreInt32(&p.canceled, 1)
+				close(p.buf)
+				return nil
+			case <-p.fail:
+				p.l.Info("producer/consumer failed while paused, producer exit")
+				return nil
+			}
+		}
+		if !p.limiters.produce.Wait(ctx.Done()) {
+			p.l.Info("ctx done, producer exit", ctx.Err())
+			atomic.StoreInt32(&p.canceled, 1)
+			close(p.buf)
+			return nil
+		}
+		var entry T
+		err := protect(nil, func() (err error) {
+			entry, err = p.produceFunc()
+			return
+		})
+		if err != nil {
+			if err == ErrFinished {
+				p.l.Info("produce finished, producer exit")
+				close(p.buf)
+				return nil
+			} else {
+				p.l.Error("produce failed, producer exit", err)
+				closeChanSafely(p.fail)
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			p.l.Info("ctx done, producer exit", ctx.Err())
+			atomic.StoreInt32(&p.canceled, 1)
+			close(p.buf)
+			return nil
+		case <-p.fail:
+			p.l.Info("producer/consumer failed, producer exit")
+			return nil
+		case p.buf <- entry:
+			atomic.AddInt64(&p.produced, 1)
+			p.l.Debug("produce entry", entry)
+		}
+	}
+}
+
+func (p *Pipeline[T]) doConsume(ctx context.Context, index int) *ConsumeError {
+	done := abandonDone(ctx, p.cfg.cancelPolicy, p.abort)
+	for {
+		if isDone(done) {
+			p.l.Infof("ctx done, consumer %v exit", index)
+			atomic.StoreInt32(&p.canceled, 1)
+			return nil
+		}
+		if p.ctrl != nil {
+			// 不活跃的 worker 等待 limit 变化
+			if active, changed := p.ctrl.state(index); !active {
+				select {
+				case <-done:
+				case <-changed:
+				case <-p.fail:
+					p.l.Infof("producer/consumer failed, consumer %v exit", index)
+					return nil
+				case <-p.finished:
+					p.l.Infof("produce finished, consumer %v exit", index)
+					return nil
+				}
+				continue
+			}
+		}
+		// 暂停时不再从 buf 中取 entry；ctx 结束后不再暂停，由 CancelPolicy 决定如何处理缓冲区
+		if resumed := p.pauser.resumed(); !isDone(resumed) {
+			select {
+			case <-resumed:
+			case <-ctx.Done():
+			case <-done:
+				continue
+			case <-p.fail:
+				p.l.Infof("producer/consumer failed, consumer %v exit", index)
+				return nil
+			}
+		}
+		select {
+		case <-done:
+			continue
+		case <-p.fail:
+			p.l.Infof("producer/consumer failed, consumer %v exit", index)
+			return nil
+		case entry, ok := <-p.buf:
+			if !ok {
+				p.l.Infof("produce finished, consumer %v exit", index)
+				closeChanSafely(p.finished)
+				return nil
+			}
+
+			if !p.limiters.waitConsume(done, index) {
+				p.l.Infof("ctx done, consumer %v exit", index)
+				atomic.StoreInt32(&p.canceled, 1)
+				return nil
+			}
+			start := time.Now()
+			err := protect(entry, func() error {
+				return p.consumeFunc(entry)
+			})
+			latency := time.Since(start)
+			if p.ctrl != nil {
+				p.ctrl.observe(latency, err)
+			}
+			if err != nil {
+				p.stats[index].observe(latency, 0, 1)
+				err = p.deadLetter.handle(entry, err, 1, atomic.LoadInt64(&p.processed))
+				if err == nil {
+					p.l.Infof("consume failed, put into dead letter, consumer %v, entry: %v", index, entry)
+					continue
+				}
+				p.l.Errorf("consume failed, consumer %v exit, err: %v", index, err)
+				closeChanSafely(p.fail)
+				return &ConsumeError{Consumer: index, Entry: entry, Err: err}
+			}
+			p.stats[index].observe(latency, 1, 0)
+			atomic.AddInt64(&p.processed, 1)
+		}
+	}
+}
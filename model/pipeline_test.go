@@ -0,0 +1,82 @@
+package model
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func TestPipeline_Run(t *testing.T) {
+	var n, sum int64
+	produce := func() (int64, error) {
+		if n++; n > 100 {
+			return 0, ErrFinished
+		}
+		return n, nil
+	}
+	consume := func(v int64) error {
+		atomic.AddInt64(&sum, v)
+		return nil
+	}
+	p, err := NewPipeline(produce, consume, 4)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Run())
+	assert.Equal(t, int64(5050), sum)
+	assert.Equal(t, 0, p.BufferStatus())
+}
+
+func TestPipeline_Run_Err(t *testing.T) {
+	errProduce := errors.New("produce failed")
+	var n int64
+	p, err := NewPipeline(func() (string, error) {
+		if n++; n > 10 {
+			return "", errProduce
+		}
+		return "ok", nil
+	}, func(string) error { return nil }, 2)
+	assert.NoError(t, err)
+	err = p.Run()
+	assert.True(t, errors.Is(err, errProduce))
+
+	errConsume := errors.New("consume failed")
+	p, err = NewPipeline(func() (string, error) {
+		return "ok", nil
+	}, func(string) error { return errConsume }, 2)
+	assert.NoError(t, err)
+	err = p.Run()
+	assert.True(t, errors.Is(err, errConsume))
+
+	_, err = NewPipeline(func() (string, error) { return "", nil }, func(string) error { return nil }, 0)
+	assert.Error(t, err)
+}
+
+type countConsumer struct {
+	consumed int64
+}
+
+func (c *countConsumer) Consume(E) error {
+	atomic.AddInt64(&c.consumed, 1)
+	return nil
+}
+
+type limitProducer struct {
+	id, limit int64
+}
+
+func (p *limitProducer) Produce() (E, error) {
+	if p.id >= p.limit {
+		return nil, ErrFinished
+	}
+	p.id++
+	return simpleEntry{id: p.id}, nil
+}
+
+func TestNewEPipeline(t *testing.T) {
+	consumer := &countConsumer{}
+	p, err := NewEPipeline(&limitProducer{limit: 40}, consumer, 2)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Run())
+	assert.Equal(t, int64(40), consumer.consumed)
+}
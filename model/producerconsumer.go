@@ -4,18 +4,25 @@ Package model have some util model(模型).
 package model
 
 import (
+	"context"
 	"errors"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
-	"github.com/wanfadong/go-utils"
-	"github.com/wanfadong/go-utils/tool"
+	"sync/atomic"
+	"time"
+
 	xlog "github.com/sirupsen/logrus"
+	"github.com/wanfadong/go-utils/tool"
 )
 
 // ErrFinished is an error flag that indicates the end of producing
 var ErrFinished = errors.New("produce finished")
 
+// errOutStop 表示通过 OutStop 停止
+var errOutStop = errors.New("out stop")
+
 // E is a util interface that Produce results must implement
 type E interface {
 	String() string
@@ -46,7 +53,29 @@ type ProducerConsumerConfig struct {
 	OutStop        chan struct{}
 	Num            int
 	ScCfg          tool.SpeedometerConfig
-	MarkerFilePath string
+	MarkerFilePath string           // 等价于 Checkpoint: NewFileCheckpointStore(MarkerFilePath)
+	Checkpoint     CheckpointStore  // 保存 marker 的位置，优先于 MarkerFilePath
+	CancelPolicy   CancelPolicy     // RunContext 的 ctx 结束时如何处理缓冲区中的 entry
+	RetryPolicy    RetryPolicy      // Consume 失败时的重试策略，重试仍然失败才会记录 marker 并停止
+	DeadLetter     DeadLetterConfig // 重试仍然失败的 entry 放入死信，而不是记录 marker 并停止
+	RateLimit      RateLimitConfig  // 限制 produce 和 consume 的速度，避免压垮下游
+	// 不为 nil 时根据 consume 的耗时和错误率调整活跃的 consumer 数量，Num 为初始值，见 AdaptiveConcurrency
+	Adaptive *AdaptiveConcurrency
+	// 每隔多长时间把当前的 watermark 保存到 Checkpoint，进程被 kill 之后也可以从最后一次保存的位置续处理。
+	// <= 0 表示只在 Run 结束时（出错或者被停止）记录。
+	CheckpointInterval time.Duration
+
+	// 有序输出：Sink 不为 nil 时，consumer 调用 Transform 而不是 Consume，结果按照 produce 的顺序交给 Sink。
+	// ReorderWindow 是已经 produce 但是还没有交给 Sink 的 entry 的最大数量，达到后 producer 会阻塞；<= 0 时为 4 * Num
+	Transform     Transformer
+	Sink          Sink
+	ReorderWindow int
+
+	// 批量消费：BatchConsume 不为 nil 时代替 Consume，每次最多 BatchSize 个（默认 100），
+	// 或者第一个 entry 到达后 BatchWait（默认 1s）内收到的所有 entry。不能和 Sink 一起使用
+	BatchConsume BatchConsumer
+	BatchSize    int
+	BatchWait    time.Duration
 }
 
 // ProducerConsumerRunner is a realized Producer-Consumer model
@@ -54,15 +83,51 @@ type ProducerConsumerRunner struct {
 	xl           *xlog.Logger
 	producer     Producer
 	consumer     Consumer
-	num          int           // 消费者数量
-	buf          chan E        // 缓冲区。
-	stop         chan struct{} // 避免 consume 直接关闭 buf chan 导致 panic
-	outStop      chan struct{} // 给外部提供停止的入口
+	num          int               // 消费者数量，自适应时为初始值
+	buf          chan trackedEntry // 缓冲区。
+	stop         chan struct{}     // 避免 consume 直接关闭 buf chan 导致 panic
+	abort        chan struct{}     // Abort 之后关闭
+	outStop      chan struct{}     // 给外部提供停止的入口
 	speedCounter *tool.Speedometer
 
-	m              sync.Mutex
-	marker         int64 // 处理过程出问题时，这个值表示第一个没有处理entry的位置
-	markerFilePath string
+	m                  sync.Mutex // 保护 checkpoint 的保存
+	marker             int64      // 处理过程出问题时，这个值表示第一个没有处理entry的位置
+	cursor             string     // 使用 CursorEntry 时，第一个没有处理entry的 cursor
+	checkpoint         CheckpointStore
+	tracker            *WatermarkTracker
+	stopped            int32 // 是否因为出错或者被停止而退出，atomic 访问
+	stopOnce           sync.Once
+	stopErr            error // 导致退出的第一个错误，Run 结束后才可以读取
+	checkpointInterval time.Duration
+
+	cancelPolicy CancelPolicy
+	retryPolicy  RetryPolicy
+	deadLetter   *deadLetterHandler
+	limiters     *rateLimiters
+	ctrl         *concurrencyController // 为 nil 时 consumer 数量固定
+	finished     chan struct{}          // consumer 发现 buf 被关闭后关闭，唤醒不活跃的 consumer
+	processed    int64                  // consume 成功的数量，atomic 访问
+	canceled     int32                  // 是否因为 ctx 结束而退出，atomic 访问
+
+	transformer Transformer
+	sink        Sink
+	window      chan struct{}      // 有序输出时的 reorder window，producer 放入，sink 取出
+	results     chan orderedResult // consumer 交给 sink 的结果
+
+	batchConsumer BatchConsumer
+	batchSize     int
+	batchWait     time.Duration
+
+	name      string
+	startTime time.Time
+	produced  int64           // atomic 访问
+	stats     []consumerStats // 每个 consumer 的统计
+	state     int32           // runIdle/runRunning/runFinished，atomic 访问
+	pauser    pauser
+
+	produceErr  error           // 只由 producer 写入
+	errM        sync.Mutex      // 保护 consumeErrs
+	consumeErrs []*ConsumeError // 所有失败的 consumer（以及 sink）的错误
 }
 
 // NewProducerConsumerRunner return a ProducerConsumerRunner instance with given config
@@ -73,165 +138,612 @@ func NewProducerConsumerRunner(xl *xlog.Logger, cfg ProducerConsumerConfig) (p *
 		xl.Error(err)
 		return
 	}
-	buf := make(chan E, cfg.Num)
+	if cfg.Sink != nil && cfg.Transform == nil {
+		err = errors.New("ordered mode needs Transform")
+		xl.Error(err)
+		return
+	}
+	if cfg.Sink != nil && cfg.BatchConsume != nil {
+		err = errors.New("ordered mode can not be used with BatchConsume")
+		xl.Error(err)
+		return
+	}
+	var ctrl *concurrencyController
+	if cfg.Adaptive != nil {
+		if err = cfg.Adaptive.validate(); err != nil {
+			xl.Error(err)
+			return
+		}
+		ctrl = newConcurrencyController(*cfg.Adaptive, cfg.Num)
+	}
+	buf := make(chan trackedEntry, cfg.Num)
 	stop := make(chan struct{})
 	speedCounter := tool.NewSpeedometer(xl, cfg.ScCfg)
+	checkpoint := cfg.Checkpoint
+	if checkpoint == nil && cfg.MarkerFilePath != "" {
+		checkpoint = NewFileCheckpointStore(cfg.MarkerFilePath)
+	}
 	p = &ProducerConsumerRunner{
-		xl:             xl,
-		producer:       cfg.Produce,
-		consumer:       cfg.Consume,
-		num:            cfg.Num,
-		buf:            buf,
-		stop:           stop,
-		speedCounter:   speedCounter,
-		outStop:        cfg.OutStop,
-		markerFilePath: cfg.MarkerFilePath,
+		xl:           xl,
+		producer:     cfg.Produce,
+		consumer:     cfg.Consume,
+		num:          cfg.Num,
+		buf:          buf,
+		stop:         stop,
+		abort:        make(chan struct{}),
+		speedCounter: speedCounter,
+		outStop:      cfg.OutStop,
+		checkpoint:   checkpoint,
+		tracker:      NewWatermarkTracker(0),
+		cancelPolicy: cfg.CancelPolicy,
+		retryPolicy:  cfg.RetryPolicy,
+		deadLetter:   newDeadLetterHandler(cfg.DeadLetter),
+		ctrl:         ctrl,
+		finished:     make(chan struct{}),
+
+		checkpointInterval: cfg.CheckpointInterval,
+
+		name: cfg.ScCfg.Name,
+	}
+	p.limiters = newRateLimiters(cfg.RateLimit, p.workerNum())
+	p.stats = make([]consumerStats, p.workerNum())
+	if ctrl != nil {
+		ctrl.onChange = p.updateRateLimit
+	}
+	p.updateRateLimit(p.Workers())
+	if cfg.Sink != nil {
+		window := cfg.ReorderWindow
+		if window <= 0 {
+			window = 4 * cfg.Num
+		}
+		p.transformer = cfg.Transform
+		p.sink = cfg.Sink
+		p.window = make(chan struct{}, window)
+		p.results = make(chan orderedResult, cfg.Num)
+	}
+	if cfg.BatchConsume != nil {
+		p.batchConsumer = cfg.BatchConsume
+		p.batchSize = cfg.BatchSize
+		if p.batchSize <= 0 {
+			p.batchSize = defaultBatchSize
+		}
+		p.batchWait = cfg.BatchWait
+		if p.batchWait <= 0 {
+			p.batchWait = defaultBatchWait
+		}
 	}
 	return
 }
 
-// Run run a Produce-consume task, with given consume and Produce func.
+// Run run a Produce-consume task, with given consume and Produce func. 出错时返回 *RunError。
 // 除非所有的 consumer 都失败了，否则一定会把 Produce 出来的 entry 全部处理完成后才退出。
 // 断点处理：
 // 1. produce失败，producer 会记录失败的位置。consume会处理已经produce的数据，然后退出。
 // 2. consume失败，consumer 会记录失败的位置。producer 立即退出，并记录结束的位置。其他 consumer 会继续处理 buf 中剩余的数据。
-// 结束的位置：
-// 	producer：处理完成的最后一条
-// 	consumer：处理失败的那条数据。
-func (p *ProducerConsumerRunner) Run() {
+// 结束的位置：第一个没有处理完成的 entry（按照 produce 的顺序），见 WatermarkTracker
+//
+//	producer：处理完成的最后一条的 next marker
+//	consumer：处理失败的那条数据，或者更早的还没有处理完成的数据。
+func (p *ProducerConsumerRunner) Run() error {
+	return p.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops producing when ctx is done.
+// 缓冲区中剩余的 entry 按照 CancelPolicy 处理，marker 为第一个没有处理的 entry。
+// Produce/Consume 失败（包括 panic，见 PanicError）时返回 *RunError；
+// 只是因为 ctx 结束（或者 Abort）而退出时，返回包装了 context.Cause(ctx) 或者 ErrAborted 的错误；否则返回 nil。
+func (p *ProducerConsumerRunner) RunContext(ctx context.Context) error {
+	_, err := p.RunWithReport(ctx)
+	return err
+}
+
+// RunWithReport is like RunContext, and returns the statistics of the run
+func (p *ProducerConsumerRunner) RunWithReport(ctx context.Context) (report RunReport, err error) {
+	p.startTime = time.Now()
+	atomic.StoreInt32(&p.state, runRunning)
+	defer atomic.StoreInt32(&p.state, runFinished)
+	ctx, cancel := withAbort(ctx, p.abort)
+	defer cancel()
+	checkpointDone := make(chan struct{})
+	checkpointExited := make(chan struct{}) // checkpointLoop 退出后关闭
+	if p.checkpointInterval > 0 {
+		go func() {
+			defer close(checkpointExited)
+			p.checkpointLoop(checkpointDone)
+		}()
+	} else {
+		close(checkpointExited)
+	}
+
+	if p.ctrl != nil {
+		ctrlStop := make(chan struct{})
+		defer close(ctrlStop)
+		go p.ctrl.run(ctrlStop)
+	}
+
+	workerNum := p.workerNum()
 	wg := sync.WaitGroup{}
-	wg.Add(1 + p.num)
+	wg.Add(1 + workerNum)
 
 	go func() {
 		defer wg.Done()
-		p.doProduce()
+		p.doProduce(ctx)
 	}()
 
-	for i := 0; i < p.num; i++ {
+	for i := 0; i < workerNum; i++ {
 		go func(i int) {
 			defer wg.Done()
-			p.doConsume(i)
+			if p.batchConsumer != nil {
+				p.doConsumeBatch(ctx, i)
+				return
+			}
+			p.doConsume(ctx, i)
 		}(i)
 	}
 
+	sinkDone := make(chan struct{})
+	if p.sink != nil {
+		go func() {
+			defer close(sinkDone)
+			p.doSink()
+		}()
+	} else {
+		close(sinkDone)
+	}
+
 	wg.Wait()
+	if p.sink != nil {
+		close(p.results)
+	}
+	<-sinkDone
+	close(checkpointDone)
+	// 等待正在进行的定期保存完成，避免它覆盖最终的 marker
+	<-checkpointExited
 
-	if p.marker != 0 {
-		p.recordMarker()
+	// 被丢弃的 entry 没有完成，watermark 不会越过它们
+	if atomic.LoadInt32(&p.stopped) != 0 || p.checkpointInterval > 0 {
+		var exhausted bool
+		p.marker, p.cursor, exhausted = p.tracker.Position()
+		p.xl.Infof("final marker is %v, cursor is %q", p.marker, p.cursor)
+		if p.marker != 0 {
+			p.recordMarker(p.marker, p.cursor, exhausted, p.stopErr)
+		}
 	}
 	// 统计处理的结果
-	err := p.speedCounter.Close()
+	if closeErr := p.speedCounter.Close(); closeErr != nil {
+		p.xl.Error("speed counter close failed", closeErr)
+	}
+
+	var cancelErr error
+	if atomic.LoadInt32(&p.canceled) != 0 {
+		cancelErr = canceledError(stopCause(ctx, p.abort), atomic.LoadInt64(&p.processed))
+	}
+	err = runResult(p.produceErr, p.consumeErrs, cancelErr)
+	return p.report(err), err
+}
+
+func (p *ProducerConsumerRunner) report(err error) RunReport {
+	wall := time.Since(p.startTime)
+	report := RunReport{
+		Name:        p.name,
+		Produced:    atomic.LoadInt64(&p.produced),
+		Consumed:    atomic.LoadInt64(&p.processed),
+		DeadLetters: p.deadLetter.Accepted(),
+		Retried:     p.speedCounter.Retried(),
+		StartTime:   p.startTime,
+		WallTime:    wall,
+		Throughput:  throughput(atomic.LoadInt64(&p.processed), wall),
+		Consumers:   consumerReports(p.stats, wall),
+		Marker:      p.tracker.Watermark(),
+		Cursor:      p.tracker.Cursor(),
+		StopReason:  p.stopReason(),
+	}
+	for _, c := range report.Consumers {
+		report.Failed += c.Failed
+	}
 	if err != nil {
-		p.xl.Error("speed counter close failed", err)
+		report.Error = err.Error()
+	}
+	return report
+}
+
+func (p *ProducerConsumerRunner) stopReason() StopReason {
+	switch {
+	case p.produceErr != nil:
+		return StopProduceFailed
+	case len(p.consumeErrs) > 0:
+		return StopConsumeFailed
+	case atomic.LoadInt32(&p.canceled) != 0:
+		return StopCanceled
+	case p.stopErr == errOutStop:
+		return StopOutStop
 	}
+	return StopFinished
 }
 
-func (p *ProducerConsumerRunner) doProduce() {
+func (p *ProducerConsumerRunner) doProduce(ctx context.Context) {
 	xl := p.xl
 
-	var lastCommitEntry E
 	for {
+		if !p.producerResumed(ctx) {
+			return
+		}
+		if !p.limiters.produce.Wait(ctx.Done()) {
+			xl.Info("producer exit because of ctx done:", ctx.Err())
+			atomic.StoreInt32(&p.canceled, 1)
+			p.setStopped(stopCause(ctx, p.abort))
+			close(p.buf)
+			return
+		}
 		// todo-是不是先检查更好？
-		entry, err := p.producer.Produce()
+		var entry E
+		err := protect(nil, func() (err error) {
+			entry, err = p.producer.Produce()
+			return
+		})
 		if err != nil {
 			if err == ErrFinished {
 				xl.Info("producer exit because of finished")
 			} else {
 				xl.Info("producer exit because of Produce err:", err)
-				p.setMarker(lastCommitEntry, true)
+				p.produceErr = err
+				p.setStopped(err)
 			}
 			close(p.buf)
 			return
 		}
 
-		select {
-		case <-p.outStop:
-			xl.Info("producer exit because of out stop")
-			p.setMarker(lastCommitEntry, true)
-			close(p.buf)
-			return
-		case <-p.stop:
-			xl.Info("producer exit because of consumer err")
-			p.setMarker(lastCommitEntry, true)
-			close(p.buf)
-			return
-		case p.buf <- entry:
-			p.speedCounter.Increase()
+		// 没有放入 buf 的 entry 也会被记录，保证 watermark 不会越过它
+		seq := p.tracker.Add(entry)
+		atomic.AddInt64(&p.produced, 1)
+		// 有序输出时，先占用 reorder window 中的一个位置，window 满了会阻塞 producer
+		window := p.window
+		for sent := false; !sent; {
+			buf := p.buf
+			if window != nil {
+				buf = nil
+			}
+			select {
+			case <-ctx.Done():
+				xl.Info("producer exit because of ctx done:", ctx.Err())
+				atomic.StoreInt32(&p.canceled, 1)
+				p.setStopped(stopCause(ctx, p.abort))
+				close(p.buf)
+				return
+			case <-p.outStop:
+				xl.Info("producer exit because of out stop")
+				p.setStopped(errOutStop)
+				close(p.buf)
+				return
+			case <-p.stop:
+				xl.Info("producer exit because of consumer err")
+				p.setStopped(nil)
+				close(p.buf)
+				return
+			case window <- struct{}{}:
+				window = nil
+			case buf <- trackedEntry{entry: entry, seq: seq}:
+				p.speedCounter.Increase()
+				sent = true
+			}
 		}
-
-		lastCommitEntry = entry
 	}
 }
 
-func (p *ProducerConsumerRunner) doConsume(i int) {
+func (p *ProducerConsumerRunner) doConsume(ctx context.Context, i int) {
 	xl := p.xl
 
+	// CancelDrain 时 consumer 不关心 ctx，处理完 buf 中剩余的 entry 后退出
+	done := abandonDone(ctx, p.cancelPolicy, p.abort)
 	for {
+		if isDone(done) {
+			xl.Info("consumer exit because of ctx done, consumer index: ", i)
+			atomic.StoreInt32(&p.canceled, 1)
+			p.setStopped(stopCause(ctx, p.abort))
+			return
+		}
+		if !p.consumerActive(done, i) || !p.consumerResumed(ctx, done) {
+			continue
+		}
 		select {
-		case entry, ok := <-p.buf:
+		case <-done:
+			continue
+		case te, ok := <-p.buf:
 			// 结束
 			if !ok {
 				xl.Info("consumer exit because of finished or producer err, consumer index: ", i)
+				safeClose(p.finished)
+				return
+			}
+			entry := te.entry
+			var result interface{}
+			// 限速时拿不到 token 就被取消的 entry 没有完成，watermark 不会越过它
+			if !p.limiters.waitConsume(done, i) {
+				xl.Info("consumer exit because of ctx done, consumer index: ", i)
+				atomic.StoreInt32(&p.canceled, 1)
+				p.setStopped(stopCause(ctx, p.abort))
+				return
+			}
+			start := time.Now()
+			attempts, err := p.retryPolicy.do(done, func() error {
+				return protect(entry, func() (err error) {
+					if p.sink != nil {
+						result, err = p.transformer.Transform(entry)
+						return
+					}
+					return p.consumer.Consume(entry)
+				})
+			}, func(retry int, err error) {
+				xl.Infof("consume failed, retry: %v, consumer index: %v, entry: %v, err: %v", retry, i, entry, err)
+				p.speedCounter.IncreaseRetry()
+			})
+			// 等待重试时被取消的 entry 没有完成，和限速时被取消一样处理
+			if err == errRetryInterrupted {
+				xl.Info("consumer exit because of ctx done while retrying, consumer index: ", i)
+				atomic.StoreInt32(&p.canceled, 1)
+				p.setStopped(stopCause(ctx, p.abort))
 				return
 			}
-			err := p.consumer.Consume(entry)
+			latency := time.Since(start)
+			if p.ctrl != nil {
+				p.ctrl.observe(latency, err)
+			}
 			if err != nil {
-				xl.Infof("consumer exit because of err, consumer index: %v, err: %v", i, err)
-				p.setMarker(entry, false)
+				p.stats[i].observe(latency, 0, 1)
+				err = p.deadLetter.handle(entry, err, attempts, atomic.LoadInt64(&p.processed))
+				if err == nil {
+					xl.Infof("consume failed, put into dead letter, consumer index: %v, entry: %v", i, entry)
+					p.complete(te, nil, true)
+					continue
+				}
+				xl.Infof("consumer exit because of err, consumer index: %v, attempts: %v, err: %v", i, attempts, err)
+				xl.Infof("marker: %v, next marker: %v", entry.Marker(), entry.NextMarker())
+				p.addConsumeErr(i, entry, err)
+				p.setStopped(err)
 				safeClose(p.stop) // consumer 关闭 stop chan，而不要关闭 buf chan，避免 panic。
 				return
 			}
+			p.stats[i].observe(latency, 1, 0)
+			atomic.AddInt64(&p.processed, 1)
+			p.complete(te, result, false)
 		}
 	}
 }
 
-// 如果需要记录到文件中，那么选取最小的 marker
-func (p *ProducerConsumerRunner) setMarker(entry E, next bool) {
-	xl := p.xl
-	if entry != nil {
-		xl.Infof("marker: %v, next marker: %v, use next marker: %v", entry.Marker(), entry.NextMarker(), next)
-		p.m.Lock()
-		defer p.m.Unlock()
+// workerNum returns the number of consumer goroutines, 自适应时为 Max，其中只有一部分是活跃的
+func (p *ProducerConsumerRunner) workerNum() int {
+	if p.ctrl != nil {
+		return p.ctrl.cfg.Max
+	}
+	return p.num
+}
 
-		thisMarker := entry.Marker()
-		if next {
-			thisMarker = entry.NextMarker()
-		}
-		if p.marker == 0 || thisMarker < p.marker {
-			p.marker = thisMarker
-		}
+// Workers returns the number of active consumers
+func (p *ProducerConsumerRunner) Workers() int {
+	if p.ctrl != nil {
+		return p.ctrl.Limit()
 	}
+	return p.num
 }
 
-func (p *ProducerConsumerRunner) recordMarker() {
+// consumerActive 自适应并发时，不活跃的 consumer 等待 limit 变化，返回 false 表示需要重新检查。
+// 有 consumer 失败或者 buf 被关闭后，不活跃的 consumer 也会处理缓冲区中剩余的 entry 并退出
+func (p *ProducerConsumerRunner) consumerActive(done <-chan struct{}, i int) bool {
+	if p.ctrl == nil {
+		return true
+	}
+	active, changed := p.ctrl.state(i)
+	if active {
+		return true
+	}
+	select {
+	case <-done:
+		return false
+	case <-changed:
+		return false
+	case <-p.stop:
+	case <-p.finished:
+	}
+	return true
+}
 
-	p.xl.Infof("final marker is %v", p.marker)
-	if p.markerFilePath != "" {
-		exists, err := go_utils.IsFileExists(p.markerFilePath)
-		if err != nil {
-			p.xl.Error("get file exists failed", err)
+// producerResumed 暂停时等待 Resume，返回 false 表示 producer 已经退出
+func (p *ProducerConsumerRunner) producerResumed(ctx context.Context) bool {
+	resumed := p.pauser.resumed()
+	if isDone(resumed) {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		p.xl.Info("producer exit because of ctx done while paused:", ctx.Err())
+		atomic.StoreInt32(&p.canceled, 1)
+		p.setStopped(stopCause(ctx, p.abort))
+	case <-p.outStop:
+		p.xl.Info("producer exit because of out stop while paused")
+		p.setStopped(errOutStop)
+	case <-p.stop:
+		p.xl.Info("producer exit because of consumer err while paused")
+		p.setStopped(nil)
+	}
+	close(p.buf)
+	return false
+}
+
+// consumerResumed 暂停时等待 Resume，done 关闭时返回 false。
+// ctx 结束后不再暂停，由 CancelPolicy 决定如何处理缓冲区中剩余的 entry
+func (p *ProducerConsumerRunner) consumerResumed(ctx context.Context, done <-chan struct{}) bool {
+	resumed := p.pauser.resumed()
+	if isDone(resumed) {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Pause halts the producer and idles consumers after the current entry, 缓冲区和 marker 保持不变
+func (p *ProducerConsumerRunner) Pause() {
+	if p.pauser.Pause() {
+		p.xl.Info("paused")
+		p.speedCounter.Pause()
+	}
+}
+
+// Resume continues a paused run
+func (p *ProducerConsumerRunner) Resume() {
+	if p.pauser.Resume() {
+		p.xl.Info("resumed")
+		p.speedCounter.Resume()
+	}
+}
+
+// Status returns the current status of the run
+func (p *ProducerConsumerRunner) Status() RunStatus {
+	marker, cursor, _ := p.tracker.Position()
+	return RunStatus{
+		State:    runState(atomic.LoadInt32(&p.state), p.pauser.Paused()),
+		Produced: atomic.LoadInt64(&p.produced),
+		Consumed: atomic.LoadInt64(&p.processed),
+		Buffered: len(p.buf),
+		Workers:  p.Workers(),
+		Marker:   marker,
+		Cursor:   cursor,
+	}
+}
+
+// trackedEntry is an entry in buf with its sequence number in WatermarkTracker
+type trackedEntry struct {
+	entry E
+	seq   int64
+}
+
+// Abort stops producing, consumers exit after the current entry and the entries left in buffer are abandoned.
+// 和 ctx 结束一样会记录 marker，RunContext 返回的错误包装了 ErrAborted
+func (p *ProducerConsumerRunner) Abort() {
+	safeClose(p.abort)
+}
+
+// SetRateLimit changes the rate of all consumers at runtime, rate <= 0 时不做修改
+// 只能修改创建时已经配置了 RateLimit.Rate 的 runner
+func (p *ProducerConsumerRunner) SetRateLimit(rate float64) {
+	p.limiters.consume.SetRate(rate)
+	p.updateRateLimit(p.Workers())
+}
+
+// updateRateLimit 更新 Speedometer 显示的限速，只计算 active 个活跃的 consumer
+func (p *ProducerConsumerRunner) updateRateLimit(active int) {
+	p.speedCounter.SetRateLimit(p.limiters.effectiveRate(active))
+}
+
+// complete marks te as completed, 有序输出时交给 sink，由 sink 在 Emit 之后标记完成
+func (p *ProducerConsumerRunner) complete(te trackedEntry, result interface{}, skip bool) {
+	if p.sink != nil {
+		p.results <- orderedResult{trackedEntry: te, result: result, skip: skip}
+		return
+	}
+	p.tracker.Done(te.seq)
+}
+
+// addConsumeErr records the error of consumer i, 有序输出的 sink 失败时 i 为 -1
+func (p *ProducerConsumerRunner) addConsumeErr(i int, entry interface{}, err error) {
+	p.errM.Lock()
+	defer p.errM.Unlock()
+	p.consumeErrs = append(p.consumeErrs, &ConsumeError{Consumer: i, Entry: entry, Err: err})
+}
+
+// setStopped 表示没有处理完所有的 entry 就退出了，需要记录 marker。
+// err 是导致退出的原因，只记录第一个非 nil 的 err
+func (p *ProducerConsumerRunner) setStopped(err error) {
+	atomic.StoreInt32(&p.stopped, 1)
+	if err != nil {
+		p.stopOnce.Do(func() {
+			p.stopErr = err
+		})
+	}
+}
+
+// BufferStatus 查看缓冲区状态
+func (p *ProducerConsumerRunner) BufferStatus() int {
+	return len(p.buf)
+}
+
+// Watermark returns the marker of the first entry not completed currently
+func (p *ProducerConsumerRunner) Watermark() int64 {
+	return p.tracker.Watermark()
+}
+
+// Cursor is like Watermark, but returns the cursor when using CursorEntry
+func (p *ProducerConsumerRunner) Cursor() string {
+	return p.tracker.Cursor()
+}
+
+// checkpointLoop 定期记录 watermark，直到 done 被关闭
+func (p *ProducerConsumerRunner) checkpointLoop(done chan struct{}) {
+	ticker := time.NewTicker(p.checkpointInterval)
+	defer ticker.Stop()
+
+	var last int64
+	for {
+		select {
+		case <-done:
 			return
-		}
-		if exists {
-			err = os.Rename(p.markerFilePath, p.markerFilePath+".backup")
-			if err != nil {
-				p.xl.Error("rename file failed", p.markerFilePath, err)
-				// 出错也会继续
+		case <-ticker.C:
+			marker, cursor, exhausted := p.tracker.Position()
+			if marker != 0 && marker != last {
+				p.xl.Debugf("checkpoint marker is %v", marker)
+				p.recordMarker(marker, cursor, exhausted, nil)
+				last = marker
 			}
 		}
-		file, err := os.Create(p.markerFilePath)
-		if err != nil {
-			p.xl.Error("create file failed", p.markerFilePath, err)
-			return
+	}
+}
+
+func (p *ProducerConsumerRunner) recordMarker(marker int64, cursor string, exhausted bool, stopErr error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.checkpoint != nil {
+		cp := Checkpoint{
+			Marker:    marker,
+			Cursor:    cursor,
+			HasCursor: p.tracker.HasCursor(),
+			Exhausted: exhausted,
+			Processed: atomic.LoadInt64(&p.processed),
+			Time:      time.Now(),
 		}
-		_, err = file.WriteString(strconv.FormatInt(p.marker, 10))
+		if stopErr != nil {
+			cp.Err = stopErr.Error()
+		}
+		err := protect(nil, func() error {
+			return p.checkpoint.Save(cp)
+		})
 		if err != nil {
-			p.xl.Error("write string failed", p.marker, err)
-			return
+			p.xl.Error("save checkpoint failed", marker, err)
 		}
 	}
 }
 
+// LoadMarker reads the marker saved by ProducerConsumerRunner, 文件不存在时 exists 为 false
+func LoadMarker(path string) (marker int64, exists bool, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	exists = true
+	marker, err = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return
+}
+
 func safeClose(stop chan struct{}) {
 	select {
 	case <-stop: // 已经被关闭
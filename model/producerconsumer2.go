@@ -1,11 +1,7 @@
 package model
 
 import (
-	"errors"
-	"github.com/wanfadong/go-utils"
-	"sync"
-
-	"github.com/sirupsen/logrus"
+	"context"
 )
 
 /*
@@ -18,121 +14,65 @@ type ProduceFunc func() (Entry, error)
 
 type ConsumeFunc func(Entry) error
 
+// ProducerConsumer is an untyped Pipeline, Entry 需要 consumer 自己做类型断言。
+// 新代码建议直接使用 Pipeline[T]。
 type ProducerConsumer struct {
-	l *logrus.Entry
-
-	produceFunc ProduceFunc
-	consumeFunc ConsumeFunc
-	consumerNum int
-
-	buf  chan Entry
-	fail chan struct{}
+	p *Pipeline[Entry]
 }
 
-func NewProducerConsumer(produce ProduceFunc, consume ConsumeFunc, num int) (p *ProducerConsumer, err error) {
-
-	if num <= 0 {
-		err = errors.New("invalid consumer number")
+func NewProducerConsumer(produce ProduceFunc, consume ConsumeFunc, num int, opts ...ProducerConsumerOption) (p *ProducerConsumer, err error) {
+	pl, err := newPipeline("ProducerConsumer", produce, consume, num, opts...)
+	if err != nil {
 		return
 	}
-
-	buf := make(chan Entry, num)
-	fail := make(chan struct{})
-	p = &ProducerConsumer{
-		l:           logrus.WithField(go_utils.ReqidKey, "ProducerConsumer"),
-		produceFunc: produce,
-		consumeFunc: consume,
-		consumerNum: num,
-
-		buf:  buf,
-		fail: fail,
-	}
+	p = &ProducerConsumer{p: pl}
 	return
 }
 
 // 查看缓冲区状态
 func (p *ProducerConsumer) BufferStatus() int {
-	return len(p.buf)
+	return p.p.BufferStatus()
+}
+
+// Workers returns the number of active consumers, 见 WithAdaptiveConcurrency
+func (p *ProducerConsumer) Workers() int {
+	return p.p.Workers()
 }
 
+// Run produce & consume until produce finished or any error occurs, 出错时返回 *RunError
 func (p *ProducerConsumer) Run() error {
-	wg := sync.WaitGroup{}
-	wg.Add(1 + p.consumerNum)
-
-	var produceErr error
-	go func() {
-		defer wg.Done()
-		produceErr = p.doProduce()
-	}()
-
-	consumeErrs := make([]error, p.consumerNum)
-	for i := 0; i < p.consumerNum; i++ {
-		go func(i int) {
-			defer wg.Done()
-			consumeErrs[i] = p.doConsume(i)
-		}(i)
-	}
+	return p.p.Run()
+}
 
-	wg.Wait()
+// Pause halts the producer and idles consumers after the current entry, 缓冲区保持不变
+func (p *ProducerConsumer) Pause() {
+	p.p.Pause()
+}
 
-	if produceErr != nil {
-		return produceErr
-	}
+// Resume continues a paused run
+func (p *ProducerConsumer) Resume() {
+	p.p.Resume()
+}
 
-	for _, consumeErr := range consumeErrs {
-		if consumeErr != nil {
-			return consumeErr
-		}
-	}
+// Status returns the current status of the run
+func (p *ProducerConsumer) Status() RunStatus {
+	return p.p.Status()
+}
 
-	return nil
+// Abort stops producing, consumers exit after the current entry and the entries left in buffer are abandoned
+func (p *ProducerConsumer) Abort() {
+	p.p.Abort()
 }
 
-func (p *ProducerConsumer) doProduce() error {
-	for {
-		entry, err := p.produceFunc()
-		if err != nil {
-			if err == ErrFinished {
-				p.l.Info("produce finished, producer exit")
-				close(p.buf)
-				return nil
-			} else {
-				p.l.Error("produce failed, producer exit", err)
-				closeChanSafely(p.fail)
-				return err
-			}
-		}
-
-		select {
-		case <-p.fail:
-			p.l.Info("producer/consumer failed, producer exit")
-			return nil
-		case p.buf <- entry:
-			p.l.Debug("produce entry", entry)
-		}
-	}
+// RunWithReport is like RunContext, and returns the statistics of the run
+func (p *ProducerConsumer) RunWithReport(ctx context.Context) (RunReport, error) {
+	return p.p.RunWithReport(ctx)
 }
 
-func (p *ProducerConsumer) doConsume(index int) error {
-	for {
-		select {
-		case <-p.fail:
-			p.l.Infof("producer/consumer failed, consumer %v exit", index)
-			return nil
-		case entry, ok := <-p.buf:
-			if !ok {
-				p.l.Infof("produce finished, consumer %v exit", index)
-				return nil
-			}
-
-			err := p.consumeFunc(entry)
-			if err != nil {
-				p.l.Errorf("consume failed, consumer %v exit, err: %v", index, err)
-				closeChanSafely(p.fail)
-				return err
-			}
-		}
-	}
+// RunContext is like Run, but stops producing when ctx is done.
+// 缓冲区中剩余的 entry 按照 CancelPolicy 处理；因为 ctx 结束而退出时，返回包装了 ctx.Err() 的错误。
+func (p *ProducerConsumer) RunContext(ctx context.Context) error {
+	return p.p.RunContext(ctx)
 }
 
 func closeChanSafely(c chan struct{}) {
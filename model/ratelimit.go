@@ -0,0 +1,51 @@
+package model
+
+import (
+	"github.com/wanfadong/go-utils/tool"
+)
+
+// RateLimitConfig limits how fast entries are produced and consumed, 所有的 rate 都是 个/s，<= 0 表示不限制
+type RateLimitConfig struct {
+	ProduceRate     float64 // producer 的速度
+	Rate            float64 // 所有 consumer 合计的速度
+	PerConsumerRate float64 // 每个 consumer 的速度
+	Burst           int     // 允许突发的数量，<= 0 时为 1
+}
+
+// rateLimiters holds the limiters built from RateLimitConfig
+type rateLimiters struct {
+	produce     *tool.RateLimiter
+	consume     *tool.RateLimiter
+	perConsumer []*tool.RateLimiter
+}
+
+func newRateLimiters(cfg RateLimitConfig, num int) *rateLimiters {
+	r := &rateLimiters{
+		produce:     tool.NewRateLimiter(cfg.ProduceRate, cfg.Burst),
+		consume:     tool.NewRateLimiter(cfg.Rate, cfg.Burst),
+		perConsumer: make([]*tool.RateLimiter, num),
+	}
+	for i := range r.perConsumer {
+		r.perConsumer[i] = tool.NewRateLimiter(cfg.PerConsumerRate, cfg.Burst)
+	}
+	return r
+}
+
+// waitConsume blocks until the i-th consumer is allowed to consume an entry, done 被关闭时返回 false
+func (r *rateLimiters) waitConsume(done <-chan struct{}, i int) bool {
+	return r.perConsumer[i].Wait(done) && r.consume.Wait(done)
+}
+
+// effectiveRate returns the max speed of the first active consumers, 0 表示不限制。
+// 自适应并发时只有 index < active 的 consumer 是活跃的
+func (r *rateLimiters) effectiveRate(active int) float64 {
+	rate := r.consume.Rate()
+	var perConsumer float64
+	for _, l := range r.perConsumer[:active] {
+		perConsumer += l.Rate()
+	}
+	if rate == 0 || (perConsumer > 0 && perConsumer < rate) {
+		rate = perConsumer
+	}
+	return rate
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+func TestProducerConsumerRunner_Run_RateLimit(t *testing.T) {
+	consumer := &countConsumer{}
+	cfg := ProducerConsumerConfig{
+		Produce:   &limitProducer{limit: 30},
+		Consume:   consumer,
+		Num:       4,
+		RateLimit: RateLimitConfig{Rate: 200, PerConsumerRate: 100, Burst: 10},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(200), p.speedCounter.RateLimit())
+
+	start := time.Now()
+	p.Run()
+	assert.Equal(t, int64(30), consumer.consumed)
+	// 突发 10 个，剩下 20 个需要 0.1s
+	assert.True(t, time.Since(start) >= 90*time.Millisecond, time.Since(start))
+
+	p.SetRateLimit(1000)
+	assert.Equal(t, float64(400), p.speedCounter.RateLimit())
+}
+
+func TestProducerConsumerRunner_RateLimit_Adaptive(t *testing.T) {
+	cfg := ProducerConsumerConfig{
+		Produce:   &limitProducer{limit: 30},
+		Consume:   &countConsumer{},
+		Num:       2,
+		RateLimit: RateLimitConfig{PerConsumerRate: 100},
+		Adaptive:  &AdaptiveConcurrency{Min: 1, Max: 8},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	// 只计算活跃的 consumer，而不是 Max 个
+	assert.Equal(t, float64(200), p.speedCounter.RateLimit())
+
+	// limit 变化后更新
+	p.ctrl.observe(time.Millisecond, nil)
+	p.ctrl.adjust()
+	assert.Equal(t, 3, p.Workers())
+	assert.Equal(t, float64(300), p.speedCounter.RateLimit())
+}
+
+func TestProducerConsumer_Run_RateLimit(t *testing.T) {
+	var n int64
+	produce := func() (Entry, error) {
+		if n++; n > 30 {
+			return nil, ErrFinished
+		}
+		return n, nil
+	}
+	p, err := NewProducerConsumer(produce, func(Entry) error { return nil }, 2, WithRateLimit(RateLimitConfig{ProduceRate: 200}))
+	assert.NoError(t, err)
+
+	start := time.Now()
+	assert.NoError(t, p.Run())
+	assert.True(t, time.Since(start) >= 140*time.Millisecond, time.Since(start))
+}
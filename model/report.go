@@ -0,0 +1,165 @@
+package model
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// StopReason is why a run stopped
+type StopReason string
+
+const (
+	StopFinished      StopReason = "finished"       // produce 结束，所有的 entry 都处理完成
+	StopProduceFailed StopReason = "produce_failed" // Produce 失败
+	StopConsumeFailed StopReason = "consume_failed" // Consume（或者有序输出的 Sink）失败
+	StopCanceled      StopReason = "canceled"       // ctx 结束
+	StopOutStop       StopReason = "out_stop"       // 外部通过 OutStop 停止
+)
+
+// RunReport is the statistics of a run, 可以用 GetPrettyJson 序列化后归档
+type RunReport struct {
+	Name        string           `json:"name,omitempty"`
+	Produced    int64            `json:"produced"`
+	Consumed    int64            `json:"consumed"`     // consume 成功的数量
+	Failed      int64            `json:"failed"`       // 重试之后仍然失败的数量，包括放入 dead letter 的
+	DeadLetters int64            `json:"dead_letters"` // 放入 dead letter 的数量
+	Retried     int64            `json:"retried"`
+	StartTime   time.Time        `json:"start_time"`
+	WallTime    time.Duration    `json:"wall_time_ns"`
+	Throughput  float64          `json:"throughput"` // consume 成功的速度，个/s
+	Consumers   []ConsumerReport `json:"consumers"`
+	Marker      int64            `json:"marker,omitempty"` // 最终的 marker，见 WatermarkTracker.Watermark；记录 checkpoint 时和记录的一样
+	Cursor      string           `json:"cursor,omitempty"`
+	StopReason  StopReason       `json:"stop_reason"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// ConsumerReport is the statistics of a consumer
+type ConsumerReport struct {
+	Index      int           `json:"index"`
+	Consumed   int64         `json:"consumed"`
+	Failed     int64         `json:"failed"`
+	Throughput float64       `json:"throughput"` // 个/s
+	Latency    LatencyReport `json:"latency"`
+}
+
+// LatencyReport is the latency distribution of a consumer.
+// 处理一个 entry 的耗时（包括重试），批量消费时为处理一批的耗时；分位数误差约 10%
+type LatencyReport struct {
+	Count int64         `json:"count"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// consumerStats 由一个 consumer 写入，atomic 访问
+type consumerStats struct {
+	consumed int64
+	failed   int64
+	latency  latencyHistogram
+}
+
+func (s *consumerStats) observe(d time.Duration, consumed, failed int) {
+	atomic.AddInt64(&s.consumed, int64(consumed))
+	atomic.AddInt64(&s.failed, int64(failed))
+	s.latency.observe(d)
+}
+
+func (s *consumerStats) report(index int, wall time.Duration) ConsumerReport {
+	consumed := atomic.LoadInt64(&s.consumed)
+	return ConsumerReport{
+		Index:      index,
+		Consumed:   consumed,
+		Failed:     atomic.LoadInt64(&s.failed),
+		Throughput: throughput(consumed, wall),
+		Latency:    s.latency.report(),
+	}
+}
+
+func consumerReports(stats []consumerStats, wall time.Duration) []ConsumerReport {
+	reports := make([]ConsumerReport, len(stats))
+	for i := range stats {
+		reports[i] = stats[i].report(i, wall)
+	}
+	return reports
+}
+
+func throughput(n int64, wall time.Duration) float64 {
+	if wall <= 0 {
+		return 0
+	}
+	return float64(n) / wall.Seconds()
+}
+
+const (
+	latencySubBuckets = 8                      // 每翻一倍分为 8 个桶
+	latencyBuckets    = 48 * latencySubBuckets // 1ns ~ 2^48ns（约 3 天）
+)
+
+// latencyHistogram is a log-scale histogram, 内存固定，可以并发写入
+type latencyHistogram struct {
+	count   int64
+	sum     int64
+	max     int64
+	buckets [latencyBuckets]int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	ns := int64(d)
+	if ns < 1 {
+		ns = 1
+	}
+	i := int(math.Log2(float64(ns)) * latencySubBuckets)
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+	atomic.AddInt64(&h.buckets[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, ns)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if ns <= max || atomic.CompareAndSwapInt64(&h.max, max, ns) {
+			break
+		}
+	}
+}
+
+// quantile returns the upper bound of the bucket that the q-quantile falls in, 不超过 max
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	count := atomic.LoadInt64(&h.count)
+	if count == 0 {
+		return 0
+	}
+	max := atomic.LoadInt64(&h.max)
+	rank := int64(math.Ceil(q * float64(count)))
+	var n int64
+	for i := range h.buckets {
+		n += atomic.LoadInt64(&h.buckets[i])
+		if n >= rank {
+			upper := int64(math.Exp2(float64(i+1) / latencySubBuckets))
+			if upper > max {
+				upper = max
+			}
+			return time.Duration(upper)
+		}
+	}
+	return time.Duration(max)
+}
+
+func (h *latencyHistogram) report() LatencyReport {
+	count := atomic.LoadInt64(&h.count)
+	if count == 0 {
+		return LatencyReport{}
+	}
+	return LatencyReport{
+		Count: count,
+		Mean:  time.Duration(atomic.LoadInt64(&h.sum) / count),
+		P50:   h.quantile(0.5),
+		P90:   h.quantile(0.9),
+		P99:   h.quantile(0.99),
+		Max:   time.Duration(atomic.LoadInt64(&h.max)),
+	}
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+	"github.com/wanfadong/go-utils"
+	"github.com/wanfadong/go-utils/tool"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := latencyHistogram{}
+	assert.Equal(t, LatencyReport{}, h.report())
+
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	r := h.report()
+	assert.Equal(t, int64(100), r.Count)
+	assert.Equal(t, 50500*time.Microsecond, r.Mean)
+	assert.Equal(t, 100*time.Millisecond, r.Max)
+	// 误差在一个桶的宽度之内
+	for _, c := range []struct {
+		got, want time.Duration
+	}{{r.P50, 50 * time.Millisecond}, {r.P90, 90 * time.Millisecond}, {r.P99, 99 * time.Millisecond}} {
+		assert.True(t, c.got >= c.want, c.got)
+		assert.True(t, float64(c.got) <= float64(c.want)*1.1, c.got)
+	}
+}
+
+func TestProducerConsumerRunner_RunWithReport(t *testing.T) {
+	cfg := ProducerConsumerConfig{
+		Produce:     &limitProducer{limit: 100},
+		Consume:     &flakyConsumer{failTimes: 1, err: errTransient, attempts: map[int64]int{}},
+		Num:         2,
+		RetryPolicy: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		ScCfg:       tool.SpeedometerConfig{Name: "report"},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	report, err := p.RunWithReport(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, "report", report.Name)
+	assert.Equal(t, int64(100), report.Produced)
+	assert.Equal(t, int64(100), report.Consumed)
+	assert.Equal(t, int64(0), report.Failed)
+	assert.Equal(t, int64(100), report.Retried)
+	assert.Equal(t, StopFinished, report.StopReason)
+	// 全部完成时为最后一个 entry 的 next marker
+	assert.Equal(t, int64(101), report.Marker)
+	assert.Equal(t, 2, len(report.Consumers))
+	var consumed int64
+	for _, c := range report.Consumers {
+		consumed += c.Consumed
+		assert.Equal(t, c.Consumed, c.Latency.Count)
+	}
+	assert.Equal(t, int64(100), consumed)
+
+	s, err := go_utils.GetPrettyJson(report)
+	assert.NoError(t, err)
+	var decoded RunReport
+	assert.NoError(t, json.Unmarshal([]byte(s), &decoded))
+	assert.Equal(t, report.Consumed, decoded.Consumed)
+	assert.Equal(t, report.StopReason, decoded.StopReason)
+	assert.Equal(t, report.Marker, decoded.Marker)
+}
+
+func TestProducerConsumerRunner_RunWithReport_Failed(t *testing.T) {
+	cfg := ProducerConsumerConfig{
+		Produce: &limitProducer{limit: 100},
+		Consume: &poisonedConsumer{},
+		Num:     1,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	report, err := p.RunWithReport(context.Background())
+	assert.Error(t, err)
+
+	assert.Equal(t, StopConsumeFailed, report.StopReason)
+	assert.Equal(t, int64(2), report.Consumed)
+	assert.Equal(t, int64(1), report.Failed)
+	assert.Equal(t, int64(3), report.Marker)
+	assert.Equal(t, err.Error(), report.Error)
+}
+
+func TestPipeline_RunWithReport(t *testing.T) {
+	errConsume := errors.New("consume failed")
+	sink := &memDeadLetterSink{}
+	var n int
+	p, err := NewPipeline(func() (int, error) {
+		if n++; n > 50 {
+			return 0, ErrFinished
+		}
+		return n, nil
+	}, func(i int) error {
+		if i%10 == 0 {
+			return errConsume
+		}
+		return nil
+	}, 3, WithDeadLetter(DeadLetterConfig{Sink: sink}))
+	assert.NoError(t, err)
+	report, err := p.RunWithReport(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Pipeline", report.Name)
+	assert.Equal(t, int64(50), report.Produced)
+	assert.Equal(t, int64(45), report.Consumed)
+	assert.Equal(t, int64(5), report.Failed)
+	assert.Equal(t, int64(5), report.DeadLetters)
+	assert.Equal(t, StopFinished, report.StopReason)
+	assert.Equal(t, 3, len(report.Consumers))
+}
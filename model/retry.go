@@ -0,0 +1,82 @@
+package model
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed Consume is retried.
+// 零值表示不重试。
+type RetryPolicy struct {
+	MaxAttempts    int              // 最多尝试的次数（包括第一次），<= 1 表示不重试
+	InitialBackoff time.Duration    // 第一次重试前等待的时间
+	MaxBackoff     time.Duration    // 等待时间的上限，<= 0 表示不限制
+	Multiplier     float64          // 每次重试等待时间的倍数，<= 1 时使用 2
+	Jitter         float64          // 等待时间随机抖动的比例，取值 [0, 1]
+	Retryable      func(error) bool // 判断错误是否可以重试，nil 表示所有错误都可以重试
+}
+
+// Backoff returns the wait time before the given retry (从 1 开始)
+func (r RetryPolicy) Backoff(retry int) time.Duration {
+	multiplier := r.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+	d := float64(r.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if r.MaxBackoff > 0 && d > float64(r.MaxBackoff) {
+		d = float64(r.MaxBackoff)
+	}
+	if r.Jitter > 0 {
+		d += d * r.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// retryable 调用 Retryable，Retryable panic 时不再重试，返回 *PanicError
+func (r RetryPolicy) retryable(err error) (retry bool, panicErr error) {
+	if r.Retryable == nil {
+		return true, nil
+	}
+	panicErr = protect(nil, func() error {
+		retry = r.Retryable(err)
+		return nil
+	})
+	return
+}
+
+// errRetryInterrupted is returned by RetryPolicy.do when done is closed while waiting to retry,
+// entry 还没有用完重试次数，不能当作失败处理
+var errRetryInterrupted = errors.New("retry interrupted")
+
+// do calls fn until it succeeds, returns a permanent error, or attempts run out.
+// done 被关闭时不再重试，返回 errRetryInterrupted。onRetry 在每次重试前调用。
+// 返回尝试的次数和最后一次的错误。
+func (r RetryPolicy) do(done <-chan struct{}, fn func() error, onRetry func(retry int, err error)) (attempts int, err error) {
+	for {
+		attempts++
+		err = fn()
+		if err == nil || attempts >= r.MaxAttempts {
+			return
+		}
+		retry, panicErr := r.retryable(err)
+		if panicErr != nil {
+			err = panicErr
+			return
+		}
+		if !retry {
+			return
+		}
+
+		onRetry(attempts, err)
+		timer := time.NewTimer(r.Backoff(attempts))
+		select {
+		case <-done:
+			timer.Stop()
+			err = errRetryInterrupted
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+var errTransient = errors.New("transient")
+
+// 每个 entry 前 failTimes 次 consume 都失败
+type flakyConsumer struct {
+	m         sync.Mutex
+	failTimes int
+	err       error
+	attempts  map[int64]int
+	consumed  int
+}
+
+func (c *flakyConsumer) Consume(entry E) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.attempts[entry.Marker()]++
+	if c.attempts[entry.Marker()] <= c.failTimes {
+		return c.err
+	}
+	c.consumed++
+	return nil
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	r := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, r.Backoff(1))
+	assert.Equal(t, 2*time.Second, r.Backoff(2))
+	assert.Equal(t, 4*time.Second, r.Backoff(3))
+	assert.Equal(t, 5*time.Second, r.Backoff(4))
+
+	r.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := r.Backoff(2)
+		assert.True(t, d >= time.Second && d <= 3*time.Second, d)
+	}
+}
+
+func TestProducerConsumerRunner_Run_Retry(t *testing.T) {
+	consumer := &flakyConsumer{failTimes: 2, err: errTransient, attempts: map[int64]int{}}
+	cfg := ProducerConsumerConfig{
+		Produce:     &limitProducer{limit: 20},
+		Consume:     consumer,
+		Num:         2,
+		RetryPolicy: RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	p.Run()
+	assert.Equal(t, 20, consumer.consumed)
+	assert.Equal(t, int64(40), p.speedCounter.Retried())
+	assert.Equal(t, int64(0), p.marker)
+}
+
+func TestProducerConsumerRunner_Run_RetryPermanent(t *testing.T) {
+	errPermanent := errors.New("permanent")
+	consumer := &flakyConsumer{failTimes: 1, err: errPermanent, attempts: map[int64]int{}}
+	cfg := ProducerConsumerConfig{
+		Produce: &limitProducer{limit: 20},
+		Consume: consumer,
+		Num:     1,
+		RetryPolicy: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			Retryable: func(err error) bool {
+				return err != errPermanent
+			},
+		},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	p.Run()
+	assert.Equal(t, 0, consumer.consumed)
+	assert.Equal(t, 1, consumer.attempts[1])
+	assert.Equal(t, int64(0), p.speedCounter.Retried())
+	assert.Equal(t, int64(1), p.marker)
+}
+
+func TestProducerConsumerRunner_Run_RetryInterrupted(t *testing.T) {
+	consumer := &flakyConsumer{failTimes: 100, err: errTransient, attempts: map[int64]int{}}
+	dead := &memDeadLetterSink{}
+	cfg := ProducerConsumerConfig{
+		Produce:      &limitProducer{limit: 20},
+		Consume:      consumer,
+		Num:          1,
+		CancelPolicy: CancelAbandon,
+		RetryPolicy:  RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second},
+		DeadLetter:   DeadLetterConfig{Sink: dead},
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	report, err := p.RunWithReport(ctx)
+
+	// 等待重试时被取消：不是 consume 失败，也不放入 dead letter，marker 停在这个 entry
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	var runErr *RunError
+	assert.False(t, errors.As(err, &runErr))
+	assert.Equal(t, 1, consumer.attempts[1])
+	assert.Equal(t, 0, len(dead.letters))
+	assert.Equal(t, StopCanceled, report.StopReason)
+	assert.Equal(t, int64(0), report.Failed)
+	assert.Equal(t, int64(1), report.Marker)
+}
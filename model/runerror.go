@@ -0,0 +1,68 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConsumeError is the error of a failed consumer and the entry it failed on
+type ConsumeError struct {
+	Consumer int         // consumer index，有序输出的 sink 失败时为 -1
+	Entry    interface{} // 失败的 entry，批量消费时为失败的那一批 []E
+	Err      error
+}
+
+func (e *ConsumeError) Error() string {
+	if e.Consumer < 0 {
+		return fmt.Sprintf("sink failed, entry: %v: %v", e.Entry, e.Err)
+	}
+	return fmt.Sprintf("consumer %d failed, entry: %v: %v", e.Consumer, e.Entry, e.Err)
+}
+
+func (e *ConsumeError) Unwrap() error {
+	return e.Err
+}
+
+// RunError aggregates all errors of a run, 可以用 errors.Is/errors.As 检查其中任意一个错误
+type RunError struct {
+	Produce  error           // producer 的错误，正常结束时为 nil
+	Consume  []*ConsumeError // 所有失败的 consumer 的错误
+	Canceled error           // 同时因为 ctx 结束而退出时不为 nil
+}
+
+func (e *RunError) Error() string {
+	var msgs []string
+	if e.Produce != nil {
+		msgs = append(msgs, "produce failed: "+e.Produce.Error())
+	}
+	for _, err := range e.Consume {
+		msgs = append(msgs, err.Error())
+	}
+	if e.Canceled != nil {
+		msgs = append(msgs, e.Canceled.Error())
+	}
+	return "run failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns all the errors, 和 errors.Join 的结果一样被 errors.Is/errors.As 遍历
+func (e *RunError) Unwrap() []error {
+	var errs []error
+	if e.Produce != nil {
+		errs = append(errs, e.Produce)
+	}
+	for _, err := range e.Consume {
+		errs = append(errs, err)
+	}
+	if e.Canceled != nil {
+		errs = append(errs, e.Canceled)
+	}
+	return errs
+}
+
+// runResult 没有 produce/consume 错误时返回 canceled（可能为 nil），否则返回 *RunError
+func runResult(produce error, consume []*ConsumeError, canceled error) error {
+	if produce == nil && len(consume) == 0 {
+		return canceled
+	}
+	return &RunError{Produce: produce, Consume: consume, Canceled: canceled}
+}
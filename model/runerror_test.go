@@ -0,0 +1,86 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+// 所有 consumer 都拿到 entry 之后才一起失败
+type barrierConsumer struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+func newBarrierConsumer(n int, err error) *barrierConsumer {
+	c := &barrierConsumer{err: err}
+	c.wg.Add(n)
+	return c
+}
+
+func (c *barrierConsumer) Consume(E) error {
+	c.wg.Done()
+	c.wg.Wait()
+	return c.err
+}
+
+func TestPipeline_Run_RunError(t *testing.T) {
+	errConsume := errors.New("consume failed")
+	p, err := NewEPipeline(&limitProducer{limit: 100}, newBarrierConsumer(3, errConsume), 3)
+	assert.NoError(t, err)
+	err = p.Run()
+
+	var re *RunError
+	assert.True(t, errors.As(err, &re))
+	assert.Nil(t, re.Produce)
+	assert.Equal(t, 3, len(re.Consume))
+	consumers := map[int]bool{}
+	for _, ce := range re.Consume {
+		consumers[ce.Consumer] = true
+		assert.NotNil(t, ce.Entry)
+		assert.True(t, errors.Is(ce, errConsume))
+	}
+	assert.Equal(t, 3, len(consumers))
+	assert.True(t, errors.Is(err, errConsume))
+}
+
+func TestProducerConsumerRunner_Run_RunError(t *testing.T) {
+	errConsume := errors.New("consume failed")
+	cfg := ProducerConsumerConfig{
+		Produce: &limitProducer{limit: 100},
+		Consume: newBarrierConsumer(2, errConsume),
+		Num:     2,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	err = p.Run()
+
+	var re *RunError
+	assert.True(t, errors.As(err, &re))
+	assert.Equal(t, 2, len(re.Consume))
+	assert.True(t, errors.Is(err, errConsume))
+	assert.Equal(t, int64(1), p.marker)
+}
+
+func TestRunError(t *testing.T) {
+	errProduce := errors.New("produce failed")
+	errConsume := errors.New("consume failed")
+	err := runResult(errProduce, []*ConsumeError{{Consumer: 1, Entry: 7, Err: errConsume}},
+		canceledError(context.Canceled, 3))
+
+	assert.True(t, errors.Is(err, errProduce))
+	assert.True(t, errors.Is(err, errConsume))
+	assert.True(t, errors.Is(err, context.Canceled))
+	// 可以再和其他错误 join
+	assert.True(t, errors.Is(errors.Join(err, errors.New("other")), errConsume))
+	assert.Equal(t, "run failed: produce failed: produce failed; consumer 1 failed, entry: 7: consume failed; "+
+		fmt.Sprintf("run canceled, 3 entries processed: %v", context.Canceled), err.Error())
+
+	assert.Nil(t, runResult(nil, nil, nil))
+	assert.Equal(t, context.Canceled, runResult(nil, nil, context.Canceled))
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AbortableRunner is a runner that can be stopped gracefully by ctx and immediately by Abort,
+// ProducerConsumerRunner、Pipeline 和 ProducerConsumer 都实现了这个接口
+type AbortableRunner interface {
+	RunContext(ctx context.Context) error
+	Abort()
+}
+
+// ShutdownConfig is the config of RunWithSignals
+type ShutdownConfig struct {
+	Signals      []os.Signal   // 默认为 SIGINT 和 SIGTERM
+	DrainTimeout time.Duration // 第一个信号之后等待处理完缓冲区的时间，超时后 Abort，<= 0 表示一直等待
+}
+
+// RunWithSignals runs r and binds OS signals to it:
+// 第一个信号停止 produce，处理完缓冲区中剩余的 entry 后退出（CancelAbandon 时立即退出）；
+// 第二个信号或者 DrainTimeout 超时后 Abort，consumer 处理完当前的 entry 后立即退出。
+// 两种情况下 ProducerConsumerRunner 都会在退出前记录 marker。
+func RunWithSignals(ctx context.Context, r AbortableRunner, cfg ShutdownConfig) error {
+	signals := cfg.Signals
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, signals...)
+	defer signal.Stop(sigCh)
+
+	return runWithSignals(ctx, r, cfg.DrainTimeout, sigCh)
+}
+
+func runWithSignals(ctx context.Context, r AbortableRunner, drainTimeout time.Duration, sigCh <-chan os.Signal) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.RunContext(ctx)
+	}()
+
+	draining := false
+	var timeout <-chan time.Time
+	for {
+		select {
+		case err := <-errCh:
+			return err
+		case sig := <-sigCh:
+			if !draining {
+				logrus.Infof("received signal %v, stop producing and drain the buffer", sig)
+				draining = true
+				cancel()
+				if drainTimeout > 0 {
+					timer := time.NewTimer(drainTimeout)
+					defer timer.Stop()
+					timeout = timer.C
+				}
+				continue
+			}
+			logrus.Infof("received signal %v again, abort", sig)
+			r.Abort()
+			sigCh = nil
+		case <-timeout:
+			logrus.Infof("drain timeout after %v, abort", drainTimeout)
+			r.Abort()
+			timeout = nil
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+)
+
+// 每个 entry 开始处理时通知 started，然后等待 release 被关闭
+type gateConsumer struct {
+	started  chan struct{}
+	release  chan struct{}
+	consumed int64
+}
+
+func newGateConsumer() *gateConsumer {
+	return &gateConsumer{started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (c *gateConsumer) Consume(E) error {
+	select {
+	case c.started <- struct{}{}:
+	default:
+	}
+	<-c.release
+	atomic.AddInt64(&c.consumed, 1)
+	return nil
+}
+
+func newSignalRunner(t *testing.T, consumer Consumer) *ProducerConsumerRunner {
+	cfg := ProducerConsumerConfig{
+		Produce: &infiniteProducer{},
+		Consume: consumer,
+		Num:     2,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	return p
+}
+
+func runSignalRunner(p AbortableRunner, drainTimeout time.Duration, sigCh chan os.Signal) chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runWithSignals(context.Background(), p, drainTimeout, sigCh)
+	}()
+	return errCh
+}
+
+func TestRunWithSignals_Drain(t *testing.T) {
+	consumer := newGateConsumer()
+	p := newSignalRunner(t, consumer)
+	sigCh := make(chan os.Signal, 2)
+	errCh := runSignalRunner(p, 0, sigCh)
+
+	<-consumer.started
+	sigCh <- syscall.SIGINT
+	time.Sleep(10 * time.Millisecond)
+	close(consumer.release)
+	err := <-errCh
+
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, errors.Is(err, ErrAborted))
+	// 缓冲区中的 entry 都被处理了，只剩下 producer 还没有放入缓冲区的那个
+	assert.Equal(t, atomic.LoadInt64(&p.produced), consumer.consumed+1)
+	assert.Equal(t, consumer.consumed+1, p.marker)
+}
+
+func TestRunWithSignals_Abort(t *testing.T) {
+	consumer := newGateConsumer()
+	p := newSignalRunner(t, consumer)
+	sigCh := make(chan os.Signal, 2)
+	errCh := runSignalRunner(p, 0, sigCh)
+
+	<-consumer.started
+	<-consumer.started
+	sigCh <- syscall.SIGINT
+	sigCh <- syscall.SIGTERM
+	<-p.abort
+	close(consumer.release)
+	err := <-errCh
+
+	assert.True(t, errors.Is(err, ErrAborted))
+	// 只处理了正在处理的两个 entry，缓冲区中的被丢弃
+	assert.Equal(t, int64(2), consumer.consumed)
+	assert.True(t, atomic.LoadInt64(&p.produced) > 2)
+	assert.Equal(t, int64(3), p.marker)
+}
+
+func TestRunWithSignals_DrainTimeout(t *testing.T) {
+	consumer := newGateConsumer()
+	p := newSignalRunner(t, consumer)
+	sigCh := make(chan os.Signal, 2)
+	errCh := runSignalRunner(p, 20*time.Millisecond, sigCh)
+
+	<-consumer.started
+	sigCh <- syscall.SIGINT
+	<-p.abort
+	close(consumer.release)
+
+	assert.True(t, errors.Is(<-errCh, ErrAborted))
+	assert.True(t, p.marker > 0)
+}
+
+func TestPipeline_Abort(t *testing.T) {
+	consumer := newGateConsumer()
+	p, err := NewEPipeline(&infiniteProducer{}, consumer, 2)
+	assert.NoError(t, err)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Run()
+	}()
+
+	<-consumer.started
+	p.Abort()
+	close(consumer.release)
+
+	err = <-errCh
+	assert.True(t, errors.Is(err, ErrAborted))
+	assert.True(t, consumer.consumed <= 2)
+}
@@ -0,0 +1,245 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wanfadong/go-utils"
+	"github.com/wanfadong/go-utils/tool"
+)
+
+// errStageStopped is returned by emit when the stage has been stopped
+var errStageStopped = errors.New("stage stopped")
+
+// StageFunc processes an entry of a stage, and passes outputs to the next stage by emit.
+// 一个输入可以 emit 0 到多个输出；emit 返回错误时表示 stage 已经停止，StageFunc 应该直接返回这个错误。
+// 最后一个 stage 的 emit 会丢弃输出。
+type StageFunc func(in Entry, emit func(out Entry) error) error
+
+// Stage is a step of MultiStagePipeline
+type Stage struct {
+	Name    string
+	Num     int // worker 数量
+	BufSize int // 输入缓冲区大小，<= 0 时等于 Num
+	Process StageFunc
+	ScCfg   tool.SpeedometerConfig // Name 为空时使用 Stage.Name
+}
+
+// MultiStagePipeline chains a producer and several stages, outputs of one stage feed the next.
+// 某个 stage 出错时，它和它上游的 stage（包括 producer）会立即停止，下游的 stage 处理完已经 emit 的数据后退出。
+type MultiStagePipeline struct {
+	l *logrus.Entry
+
+	produceFunc ProduceFunc
+	stages      []Stage
+	bufs        []chan Entry        // bufs[i] 是 stages[i] 的输入
+	stops       []chan struct{}     // stops[0] 给 producer 使用，stops[i+1] 给 stages[i] 使用
+	counters    []*tool.Speedometer // 每个 stage 处理的数量
+}
+
+// NewMultiStagePipeline return a MultiStagePipeline with given produce func and stages
+func NewMultiStagePipeline(produce ProduceFunc, stages ...Stage) (p *MultiStagePipeline, err error) {
+	if len(stages) == 0 {
+		err = errors.New("no stage")
+		return
+	}
+
+	// 先检查所有的 stage，再创建 Speedometer，避免出错时已经创建的 Speedometer 的输出 goroutine 泄漏
+	for _, stage := range stages {
+		if stage.Num <= 0 {
+			err = fmt.Errorf("invalid worker number of stage %v", stage.Name)
+			return
+		}
+	}
+
+	p = &MultiStagePipeline{
+		l:           logrus.WithField(go_utils.ReqidKey, "MultiStagePipeline"),
+		produceFunc: produce,
+		stages:      stages,
+		bufs:        make([]chan Entry, len(stages)),
+		stops:       make([]chan struct{}, len(stages)+1),
+		counters:    make([]*tool.Speedometer, len(stages)),
+	}
+	p.stops[0] = make(chan struct{})
+	for i, stage := range stages {
+		bufSize := stage.BufSize
+		if bufSize <= 0 {
+			bufSize = stage.Num
+		}
+		scCfg := stage.ScCfg
+		if scCfg.Name == "" {
+			scCfg.Name = stage.Name
+		}
+		p.bufs[i] = make(chan Entry, bufSize)
+		p.stops[i+1] = make(chan struct{})
+		p.counters[i] = tool.NewSpeedometer(logrus.StandardLogger(), scCfg)
+	}
+	return
+}
+
+// BufferStatus 查看每个 stage 输入缓冲区的状态
+func (p *MultiStagePipeline) BufferStatus() []int {
+	status := make([]int, len(p.bufs))
+	for i, buf := range p.bufs {
+		status[i] = len(buf)
+	}
+	return status
+}
+
+// Processed returns the number of entries processed by each stage
+func (p *MultiStagePipeline) Processed() []int64 {
+	processed := make([]int64, len(p.counters))
+	for i := range p.counters {
+		processed[i] = p.counters[i].Processed()
+	}
+	return processed
+}
+
+// Run runs until produce finished or any error occurs, and returns the first error (按照 producer、stage 的顺序)
+func (p *MultiStagePipeline) Run() error {
+	return p.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops producing when ctx is done, stages will process entries already produced then exit.
+func (p *MultiStagePipeline) RunContext(ctx context.Context) error {
+	wgs := make([]sync.WaitGroup, len(p.stages))
+	errs := make([][]error, len(p.stages))
+
+	var produceErr error
+	var canceled bool
+	produceDone := make(chan struct{})
+	go func() {
+		defer close(produceDone)
+		canceled, produceErr = p.doProduce(ctx)
+		close(p.bufs[0])
+	}()
+
+	for i, stage := range p.stages {
+		errs[i] = make([]error, stage.Num)
+		wgs[i].Add(stage.Num)
+		for j := 0; j < stage.Num; j++ {
+			go func(i, j int) {
+				defer wgs[i].Done()
+				errs[i][j] = p.doStage(i, j)
+			}(i, j)
+		}
+		// 当前 stage 的 worker 都退出后，关闭下一个 stage 的输入
+		go func(i int) {
+			wgs[i].Wait()
+			if i+1 < len(p.bufs) {
+				close(p.bufs[i+1])
+			}
+		}(i)
+	}
+
+	<-produceDone
+	for i := range wgs {
+		wgs[i].Wait()
+	}
+
+	for i := range p.counters {
+		if err := p.counters[i].Close(); err != nil {
+			p.l.Error("speed counter close failed", err)
+		}
+	}
+
+	if produceErr != nil {
+		return produceErr
+	}
+	for _, stageErrs := range errs {
+		for _, err := range stageErrs {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if canceled {
+		return canceledError(ctx.Err(), p.Processed()[len(p.stages)-1])
+	}
+	return nil
+}
+
+// failFrom stops the i-th stage and all its upstream stages (i == 0 表示 producer)
+func (p *MultiStagePipeline) failFrom(i int) {
+	for j := 0; j <= i; j++ {
+		closeChanSafely(p.stops[j])
+	}
+}
+
+func (p *MultiStagePipeline) doProduce(ctx context.Context) (canceled bool, err error) {
+	for {
+		var entry Entry
+		err = protect(nil, func() (err error) {
+			entry, err = p.produceFunc()
+			return
+		})
+		if err != nil {
+			if err == ErrFinished {
+				p.l.Info("produce finished, producer exit")
+				return false, nil
+			}
+			p.l.Error("produce failed, producer exit", err)
+			p.failFrom(0)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			p.l.Info("ctx done, producer exit", ctx.Err())
+			return true, nil
+		case <-p.stops[0]:
+			p.l.Info("downstream stage failed, producer exit")
+			return false, nil
+		case p.bufs[0] <- entry:
+		}
+	}
+}
+
+func (p *MultiStagePipeline) doStage(i, index int) error {
+	stage := p.stages[i]
+	stop := p.stops[i+1]
+	emit := func(out Entry) error {
+		return nil
+	}
+	if i+1 < len(p.stages) {
+		next := p.bufs[i+1]
+		emit = func(out Entry) error {
+			select {
+			case <-stop:
+				return errStageStopped
+			case next <- out:
+				return nil
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-stop:
+			p.l.Infof("stage %v stopped, worker %v exit", stage.Name, index)
+			return nil
+		case entry, ok := <-p.bufs[i]:
+			if !ok {
+				p.l.Infof("upstream finished, stage %v worker %v exit", stage.Name, index)
+				return nil
+			}
+
+			err := protect(entry, func() error {
+				return stage.Process(entry, emit)
+			})
+			if err == errStageStopped {
+				p.l.Infof("stage %v stopped, worker %v exit", stage.Name, index)
+				return nil
+			}
+			if err != nil {
+				p.l.Errorf("stage %v failed, worker %v exit, err: %v", stage.Name, index, err)
+				p.failFrom(i + 1)
+				return fmt.Errorf("stage %v: %w", stage.Name, err)
+			}
+			p.counters[i].Increase()
+		}
+	}
+}
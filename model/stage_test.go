@@ -0,0 +1,96 @@
+package model
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	"github.com/wanfadong/go-utils/tool"
+)
+
+func listProducer(n int) ProduceFunc {
+	i := 0
+	return func() (Entry, error) {
+		if i >= n {
+			return nil, ErrFinished
+		}
+		i++
+		return i, nil
+	}
+}
+
+func TestMultiStagePipeline_Run(t *testing.T) {
+	m := sync.Mutex{}
+	written := map[string]bool{}
+
+	p, err := NewMultiStagePipeline(listProducer(100),
+		Stage{Name: "fetch", Num: 4, Process: func(in Entry, emit func(Entry) error) error {
+			// 每个输入产生两个输出
+			if err := emit(in.(int) * 2); err != nil {
+				return err
+			}
+			return emit(in.(int)*2 + 1)
+		}},
+		Stage{Name: "transform", Num: 2, BufSize: 10, Process: func(in Entry, emit func(Entry) error) error {
+			return emit(strconv.Itoa(in.(int)))
+		}},
+		Stage{Name: "write", Num: 3, Process: func(in Entry, emit func(Entry) error) error {
+			m.Lock()
+			written[in.(string)] = true
+			m.Unlock()
+			return nil
+		}},
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Run())
+	assert.Equal(t, 200, len(written))
+	assert.Equal(t, []int64{100, 200, 200}, p.Processed())
+	assert.Equal(t, []int{0, 0, 0}, p.BufferStatus())
+}
+
+func TestMultiStagePipeline_Run_StageErr(t *testing.T) {
+	errTransform := errors.New("transform failed")
+	var written int64
+	m := sync.Mutex{}
+
+	p, err := NewMultiStagePipeline(func() (Entry, error) { return 1, nil }, // 不会结束的 producer
+		Stage{Name: "fetch", Num: 2, Process: func(in Entry, emit func(Entry) error) error {
+			return emit(in)
+		}},
+		Stage{Name: "transform", Num: 2, Process: func(in Entry, emit func(Entry) error) error {
+			m.Lock()
+			defer m.Unlock()
+			if written >= 10 {
+				return errTransform
+			}
+			written++
+			return emit(in)
+		}},
+		Stage{Name: "write", Num: 1, Process: func(in Entry, emit func(Entry) error) error {
+			return nil
+		}},
+	)
+	assert.NoError(t, err)
+	err = p.Run()
+	assert.True(t, errors.Is(err, errTransform), err)
+	// 下游处理完已经 emit 的数据
+	assert.Equal(t, int64(10), p.Processed()[2])
+}
+
+func TestNewMultiStagePipeline_Invalid(t *testing.T) {
+	_, err := NewMultiStagePipeline(listProducer(1))
+	assert.Error(t, err)
+	_, err = NewMultiStagePipeline(listProducer(1), Stage{Name: "bad"})
+	assert.Error(t, err)
+
+	// 后面的 stage 不合法时，不会留下前面 stage 的 Speedometer 输出 goroutine
+	before := runtime.NumGoroutine()
+	good := Stage{Name: "good", Num: 1, ScCfg: tool.SpeedometerConfig{OutputInterval: time.Hour}}
+	_, err = NewMultiStagePipeline(listProducer(1), good, Stage{Name: "bad"})
+	assert.Error(t, err)
+	assert.Equal(t, before, runtime.NumGoroutine())
+}
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"sync"
+)
+
+// WatermarkTracker tracks entries in produce order and computes the low watermark:
+// 第一个没有处理完成的 entry 的 marker，它之前的 entry 都已经处理完成，可以从这里安全地续处理。
+// 多个 consumer 乱序完成时，只有连续完成的前缀才会推进 watermark。
+// 可以并发使用。
+type WatermarkTracker struct {
+	m         sync.Mutex
+	items     []watermarkItem // 还没有推进 watermark 的 entry，按照 produce 的顺序
+	firstSeq  int64           // items[0] 的序号
+	nextSeq   int64           // 下一个 Add 的 entry 的序号
+	watermark int64           // 所有 entry 都完成时的 watermark
+	cursor    string          // 所有 entry 都完成时的 cursor watermark，见 CursorEntry
+	hasCursor bool            // 是否 Add 过 CursorEntry
+}
+
+type watermarkItem struct {
+	marker     int64
+	nextMarker int64
+	cursor     string
+	nextCursor string
+	done       bool
+}
+
+// cursorMarker is implemented by entries using string cursors as markers
+type cursorMarker interface {
+	Cursor() string
+	NextCursor() string
+}
+
+// NewWatermarkTracker returns a WatermarkTracker, start 是还没有 entry 时的 watermark
+func NewWatermarkTracker(start int64) *WatermarkTracker {
+	return &WatermarkTracker{watermark: start}
+}
+
+// Add records a produced entry, returns its sequence number used by Done
+func (w *WatermarkTracker) Add(entry E) (seq int64) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	item := watermarkItem{marker: entry.Marker(), nextMarker: entry.NextMarker()}
+	if c, ok := entry.(cursorMarker); ok {
+		item.cursor, item.nextCursor = c.Cursor(), c.NextCursor()
+		w.hasCursor = true
+	}
+	w.items = append(w.items, item)
+	seq = w.nextSeq
+	w.nextSeq++
+	return
+}
+
+// Done marks the entry with seq as completed
+func (w *WatermarkTracker) Done(seq int64) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	i := seq - w.firstSeq
+	if i < 0 || i >= int64(len(w.items)) {
+		return
+	}
+	w.items[i].done = true
+
+	// 推进连续完成的前缀
+	n := 0
+	for n < len(w.items) && w.items[n].done {
+		w.watermark = w.items[n].nextMarker
+		w.cursor = w.items[n].nextCursor
+		n++
+	}
+	if n > 0 {
+		w.items = w.items[n:]
+		w.firstSeq += int64(n)
+	}
+}
+
+// Watermark returns the marker of the first entry not completed,
+// 所有 entry 都完成时，返回最后一个 entry 的 next marker
+func (w *WatermarkTracker) Watermark() int64 {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if len(w.items) > 0 {
+		return w.items[0].marker
+	}
+	return w.watermark
+}
+
+// Cursor is like Watermark, but returns the cursor of entries implementing CursorEntry
+func (w *WatermarkTracker) Cursor() string {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if len(w.items) > 0 {
+		return w.items[0].cursor
+	}
+	return w.cursor
+}
+
+// Position returns Watermark and Cursor under one lock, 保存 checkpoint 时两者是一致的。
+// exhausted 表示使用 CursorEntry 时所有的 entry 都已经完成，并且最后一个 entry 的 NextCursor 为空（到达末尾）
+func (w *WatermarkTracker) Position() (marker int64, cursor string, exhausted bool) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if len(w.items) > 0 {
+		return w.items[0].marker, w.items[0].cursor, false
+	}
+	return w.watermark, w.cursor, w.hasCursor && w.cursor == ""
+}
+
+// HasCursor returns whether the entries implement CursorEntry, 此时应该使用 Cursor 而不是 Watermark 续处理
+func (w *WatermarkTracker) HasCursor() bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.hasCursor
+}
+
+// Pending returns the number of entries added but not advanced into watermark
+func (w *WatermarkTracker) Pending() int {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return len(w.items)
+}
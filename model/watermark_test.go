@@ -0,0 +1,146 @@
+package model
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	xlog "github.com/sirupsen/logrus"
+	"github.com/wanfadong/go-utils"
+)
+
+func TestLoadMarker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "marker.txt")
+	_, exists, err := LoadMarker(path)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, go_utils.WriteFileAtomic(path, []byte("123\n"), 0666))
+	marker, exists, err := LoadMarker(path)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, int64(123), marker)
+}
+
+func TestWatermarkTracker(t *testing.T) {
+	w := NewWatermarkTracker(1)
+	assert.Equal(t, int64(1), w.Watermark())
+
+	seqs := make([]int64, 5)
+	for i := range seqs {
+		seqs[i] = w.Add(simpleEntry{id: int64(i + 1)})
+	}
+	assert.Equal(t, int64(1), w.Watermark())
+
+	// 乱序完成，只有连续的前缀推进 watermark
+	w.Done(seqs[1])
+	w.Done(seqs[3])
+	assert.Equal(t, int64(1), w.Watermark())
+	w.Done(seqs[0])
+	assert.Equal(t, int64(3), w.Watermark())
+	w.Done(seqs[2])
+	assert.Equal(t, int64(5), w.Watermark())
+	assert.Equal(t, 1, w.Pending())
+	w.Done(seqs[4])
+	assert.Equal(t, int64(6), w.Watermark())
+	assert.Equal(t, 0, w.Pending())
+	marker, cursor, exhausted := w.Position()
+	assert.Equal(t, int64(6), marker)
+	assert.Equal(t, "", cursor)
+	assert.False(t, exhausted)
+
+	// 重复或者无效的 seq 被忽略
+	w.Done(seqs[4])
+	w.Done(100)
+	assert.Equal(t, int64(6), w.Watermark())
+}
+
+// entry 2 失败前，entry 1 还在处理；entry 3 之后都处理成功
+type outOfOrderConsumer struct {
+	m        sync.Mutex
+	consumed map[int64]bool
+	failing  chan struct{}
+}
+
+func (c *outOfOrderConsumer) Consume(entry E) error {
+	switch entry.Marker() {
+	case 1:
+		<-c.failing
+		time.Sleep(10 * time.Millisecond)
+	case 2:
+		close(c.failing)
+		return errors.New("consume 2 failed")
+	}
+	c.m.Lock()
+	c.consumed[entry.Marker()] = true
+	c.m.Unlock()
+	return nil
+}
+
+func TestProducerConsumerRunner_Run_Watermark(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "marker.txt")
+	consumer := &outOfOrderConsumer{consumed: map[int64]bool{}, failing: make(chan struct{})}
+	cfg := ProducerConsumerConfig{
+		Produce:        &limitProducer{limit: 40},
+		Consume:        consumer,
+		Num:            4,
+		MarkerFilePath: path,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+	p.Run()
+
+	// entry 1 在 entry 2 失败之后才完成，marker 是失败的 entry 2，而不是更大的位置
+	assert.True(t, consumer.consumed[1])
+	assert.Equal(t, int64(2), p.marker)
+	marker, exists, err := LoadMarker(path)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, int64(2), marker)
+}
+
+type slowConsumer struct {
+	sleep time.Duration
+}
+
+func (c *slowConsumer) Consume(E) error {
+	time.Sleep(c.sleep)
+	return nil
+}
+
+func TestProducerConsumerRunner_Run_Checkpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "marker.txt")
+	cfg := ProducerConsumerConfig{
+		Produce:            &limitProducer{limit: 40},
+		Consume:            &slowConsumer{sleep: 5 * time.Millisecond},
+		Num:                2,
+		MarkerFilePath:     path,
+		CheckpointInterval: 10 * time.Millisecond,
+	}
+	p, err := NewProducerConsumerRunner(xlog.New(), cfg)
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		p.Run()
+		close(done)
+	}()
+
+	// 运行过程中就会记录 marker
+	var checkpoint int64
+	for checkpoint == 0 {
+		time.Sleep(5 * time.Millisecond)
+		checkpoint, _, err = LoadMarker(path)
+		assert.NoError(t, err)
+	}
+	assert.True(t, checkpoint > 0 && checkpoint <= 41, checkpoint)
+	<-done
+
+	// 正常结束时记录最后一个 entry 的 next marker
+	marker, _, err := LoadMarker(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(41), marker)
+}
@@ -3,14 +3,64 @@ package go_utils
 import (
 	"encoding/base64"
 	"encoding/binary"
-	"github.com/pkg/errors"
+	"os"
+	"sync/atomic"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 var (
 	errInvalidArgs = errors.New("invalid args")
 )
 
+var defaultReqidGenerator = NewReqidGenerator()
+
+// NewReqid returns a new reqid generated by the default ReqidGenerator of this process
+func NewReqid() string {
+	return defaultReqidGenerator.Generate()
+}
+
+// ReqidGenerator generates reqids that can be decoded by DecodeReqid.
+// 同一个 generator 生成的 reqid 不会重复：并发调用时如果落在同一纳秒（或者时钟回拨），会顺延到上一个时间 +1ns。
+type ReqidGenerator struct {
+	pid      uint32
+	lastNano int64 // 上一次使用的 unixNano，atomic 访问
+}
+
+// NewReqidGenerator returns a ReqidGenerator using the pid of current process
+func NewReqidGenerator() *ReqidGenerator {
+	return NewReqidGeneratorWithPid(uint32(os.Getpid()))
+}
+
+// NewReqidGeneratorWithPid returns a ReqidGenerator using the given pid
+func NewReqidGeneratorWithPid(pid uint32) *ReqidGenerator {
+	return &ReqidGenerator{pid: pid}
+}
+
+// Generate returns a unique reqid
+func (g *ReqidGenerator) Generate() string {
+	for {
+		last := atomic.LoadInt64(&g.lastNano)
+		now := time.Now().UnixNano()
+		if now <= last {
+			now = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&g.lastNano, last, now) {
+			return EncodeReqid(g.pid, time.Unix(0, now))
+		}
+	}
+}
+
+// EncodeReqid encodes pid and t into a reqid, it is the reverse of DecodeReqid.
+// 格式：12 字节（小端序 4 字节 pid + 8 字节 unixNano）的 URL-safe base64
+func EncodeReqid(pid uint32, t time.Time) string {
+	var b [12]byte
+	binary.LittleEndian.PutUint32(b[:4], pid)
+	binary.LittleEndian.PutUint64(b[4:], uint64(t.UnixNano()))
+	return base64.URLEncoding.EncodeToString(b[:])
+}
+
 func DecodeReqid(reqid string) (pid uint32, t time.Time, err error) {
 	b, err := base64.URLEncoding.DecodeString(reqid)
 	if err != nil {
@@ -0,0 +1,66 @@
+package go_utils
+
+import (
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/golib/assert"
+)
+
+func TestEncodeReqid_RoundTrip(t *testing.T) {
+	f := func(pid uint32, unixNano int64) bool {
+		if unixNano < 0 {
+			unixNano = -unixNano
+		}
+		reqid := EncodeReqid(pid, time.Unix(0, unixNano))
+		pid2, t2, err := DecodeReqid(reqid)
+		return err == nil && pid2 == pid && t2.UnixNano() == unixNano
+	}
+	assert.NoError(t, quick.Check(f, &quick.Config{MaxCount: 10000}))
+}
+
+func TestReqidGenerator_RoundTrip(t *testing.T) {
+	g := NewReqidGeneratorWithPid(1234)
+	f := func() bool {
+		before := time.Now()
+		pid, t2, err := DecodeReqid(g.Generate())
+		return err == nil && pid == 1234 && !t2.Before(before)
+	}
+	assert.NoError(t, quick.Check(f, nil))
+}
+
+func TestReqidGenerator_Unique(t *testing.T) {
+	g := NewReqidGenerator()
+	workers, n := 16, 5000
+
+	reqids := make([][]string, workers)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				reqids[i] = append(reqids[i], g.Generate())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, workers*n)
+	for _, rs := range reqids {
+		for _, r := range rs {
+			assert.False(t, seen[r], "duplicated reqid", r)
+			seen[r] = true
+		}
+	}
+	assert.Equal(t, workers*n, len(seen))
+}
+
+func TestDecodeReqid_Invalid(t *testing.T) {
+	_, _, err := DecodeReqid("not base64!")
+	assert.Error(t, err)
+	_, _, err = DecodeReqid(EncodeReqid(1, time.Now())[:8])
+	assert.Error(t, err)
+}
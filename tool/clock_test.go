@@ -0,0 +1,67 @@
+package tool
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock 只有调用 Advance 时时间才会前进
+type fakeClock struct {
+	m       sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.m.Lock()
+	defer c.m.Unlock()
+	t := &fakeTicker{c: make(chan time.Time), d: d, next: c.now.Add(d), clock: c}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance 前进 d，到期的 ticker 被依次触发，触发时阻塞直到 tick 被接收
+func (c *fakeClock) Advance(d time.Duration) {
+	c.m.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var fired []*fakeTicker
+	for _, t := range c.tickers {
+		if !t.stopped && !t.next.After(now) {
+			t.next = now.Add(t.d)
+			fired = append(fired, t)
+		}
+	}
+	c.m.Unlock()
+	for _, t := range fired {
+		t.c <- now
+	}
+}
+
+type fakeTicker struct {
+	c       chan time.Time
+	d       time.Duration
+	next    time.Time
+	stopped bool
+	clock   *fakeClock
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.m.Lock()
+	defer t.clock.m.Unlock()
+	t.stopped = true
+}
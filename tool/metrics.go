@@ -0,0 +1,126 @@
+package tool
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricType is the type of a metric in Prometheus text exposition format
+type MetricType string
+
+const (
+	MetricCounter MetricType = "counter"
+	MetricGauge   MetricType = "gauge"
+)
+
+// Metric is a sample in Prometheus text exposition format
+type Metric struct {
+	Name   string
+	Help   string
+	Type   MetricType
+	Labels map[string]string
+	Value  float64
+}
+
+// MetricsSource provides metrics, 每次抓取时调用，可能被并发调用
+type MetricsSource interface {
+	Metrics() []Metric
+}
+
+// MetricsHandler serves the metrics of sources in Prometheus text exposition format (0.0.4),
+// 不依赖 Prometheus client，可以直接挂载到 /metrics
+func MetricsHandler(sources ...MetricsSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metrics []Metric
+		for _, s := range sources {
+			metrics = append(metrics, s.Metrics()...)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w, metrics)
+	})
+}
+
+// WriteMetrics writes metrics in Prometheus text exposition format,
+// 同名的 metric 放在一起，HELP 和 TYPE 只输出一次（使用第一个的）
+func WriteMetrics(w io.Writer, metrics []Metric) error {
+	var names []string
+	groups := map[string][]Metric{}
+	for _, m := range metrics {
+		if _, ok := groups[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		groups[m.Name] = append(groups[m.Name], m)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		group := groups[name]
+		if group[0].Help != "" {
+			bw.WriteString("# HELP " + name + " " + escapeHelp(group[0].Help) + "\n")
+		}
+		if group[0].Type != "" {
+			bw.WriteString("# TYPE " + name + " " + string(group[0].Type) + "\n")
+		}
+		for _, m := range group {
+			bw.WriteString(name + formatLabels(m.Labels) + " " + strconv.FormatFloat(m.Value, 'g', -1, 64) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + `="` + labelReplacer.Replace(labels[k]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+// Metrics returns the metrics of the Speedometer, Name 不为空时作为 name label
+func (s *Speedometer) Metrics() []Metric {
+	snap := s.Snapshot()
+	var labels map[string]string
+	if s.name != "" {
+		labels = map[string]string{"name": s.name}
+	}
+	metrics := []Metric{
+		{Name: "speedometer_processed_total", Help: "Number processed this time.", Type: MetricCounter, Value: float64(snap.Processed)},
+		{Name: "speedometer_processed_before", Help: "Number processed before this time.", Type: MetricGauge, Value: float64(snap.Before)},
+		{Name: "speedometer_total", Help: "Estimated total number, 0 if unknown.", Type: MetricGauge, Value: float64(snap.Total)},
+		{Name: "speedometer_retries_total", Help: "Number of retries.", Type: MetricCounter, Value: float64(snap.Retried)},
+		{Name: "speedometer_elapsed_seconds", Help: "Time used, excluding paused time.", Type: MetricGauge, Value: snap.Elapsed.Seconds()},
+		{Name: "speedometer_speed", Help: "Average speed per second.", Type: MetricGauge, Value: snap.Speed},
+		{Name: "speedometer_speed_1m", Help: "Speed per second of the last minute.", Type: MetricGauge, Value: snap.Speed1m},
+	}
+	if snap.HasETA() {
+		metrics = append(metrics, Metric{Name: "speedometer_eta_seconds", Help: "Estimated time left.", Type: MetricGauge, Value: snap.ETA.Seconds()})
+	}
+	if snap.RateLimit > 0 {
+		metrics = append(metrics, Metric{Name: "speedometer_rate_limit", Help: "Effective rate limit per second.", Type: MetricGauge, Value: snap.RateLimit})
+	}
+	for i := range metrics {
+		metrics[i].Labels = labels
+	}
+	return metrics
+}
@@ -0,0 +1,69 @@
+package tool
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	xlog "github.com/sirupsen/logrus"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := WriteMetrics(buf, []Metric{
+		{Name: "a_total", Help: "A \\ help\nline.", Type: MetricCounter, Labels: map[string]string{"name": "x", "job": `q"\`}, Value: 3},
+		{Name: "b", Type: MetricGauge, Value: 0.5},
+		{Name: "a_total", Help: "ignored", Type: MetricCounter, Labels: map[string]string{"name": "y\nz"}, Value: 1e21},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP a_total A \\ help\nline.
+# TYPE a_total counter
+a_total{job="q\"\\",name="x"} 3
+a_total{name="y\nz"} 1e+21
+# TYPE b gauge
+b 0.5
+`
+	if buf.String() != want {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	l := xlog.New()
+	l.Out = io.Discard
+	clock := newFakeClock()
+	s := NewSpeedometer(l, SpeedometerConfig{Name: "job", Total: 100, Clock: clock})
+	clock.Advance(time.Second)
+	s.IncreaseN(10)
+	clock.Advance(time.Second)
+	s.IncreaseRetry()
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(s).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatal("unexpected content type", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`speedometer_processed_total{name="job"} 10`,
+		`speedometer_total{name="job"} 100`,
+		`speedometer_retries_total{name="job"} 1`,
+		`speedometer_elapsed_seconds{name="job"} 2`,
+		`speedometer_speed{name="job"} 5`,
+		// 最近一分钟 5 个/s，剩余 90 个
+		`speedometer_eta_seconds{name="job"} 18`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("%q not found in:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "speedometer_rate_limit") {
+		t.Fatal("unexpected rate limit metric")
+	}
+}
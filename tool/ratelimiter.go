@@ -0,0 +1,93 @@
+package tool
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket rate limiter, 可以并发使用。
+// nil 表示不限制。
+type RateLimiter struct {
+	m      sync.Mutex
+	rate   float64 // 每秒产生的 token 数量
+	burst  float64 // 桶的容量
+	tokens float64 // 当前的 token 数量，可以是负数，表示已经被预定的 token
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing rate entries per second with burst, rate <= 0 时返回 nil
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Reserve takes a token, returns how long to wait before using it
+func (l *RateLimiter) Reserve() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.advance(time.Now())
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until a token is available or done is closed, 返回是否拿到了 token
+func (l *RateLimiter) Wait(done <-chan struct{}) bool {
+	d := l.Reserve()
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// SetRate changes the rate, 已经预定的 token 不受影响
+func (l *RateLimiter) SetRate(rate float64) {
+	if l == nil || rate <= 0 {
+		return
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.advance(time.Now())
+	l.rate = rate
+}
+
+// Rate returns the rate, nil 时返回 0
+func (l *RateLimiter) Rate() float64 {
+	if l == nil {
+		return 0
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.rate
+}
+
+func (l *RateLimiter) advance(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
@@ -0,0 +1,52 @@
+package tool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(100, 10)
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 15; j++ {
+				l.Wait(nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 60 个，突发 10 个，剩下 50 个需要 0.5s
+	used := time.Since(start)
+	if used < 450*time.Millisecond || used > time.Second {
+		t.Fatal("unexpected used time", used)
+	}
+}
+
+func TestRateLimiter_Nil(t *testing.T) {
+	l := NewRateLimiter(0, 10)
+	if l != nil {
+		t.Fatal("rate <= 0 should return nil")
+	}
+	if !l.Wait(nil) || l.Rate() != 0 {
+		t.Fatal("nil limiter should not limit")
+	}
+	l.SetRate(10)
+}
+
+func TestRateLimiter_WaitDone(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	l.Wait(nil)
+
+	done := make(chan struct{})
+	close(done)
+	if l.Wait(done) {
+		t.Fatal("should not get token after done")
+	}
+}
@@ -0,0 +1,52 @@
+package tool
+
+import (
+	"sync/atomic"
+)
+
+// rateWindowSize 是 rateWindow 最长的窗口（s）
+const rateWindowSize = 300
+
+// rateWindow is a ring buffer of per-second counters, 用于计算最近一段时间的速度，可以并发使用。
+// 每个 bucket 的高 32 位是秒，低 32 位是这一秒的数量
+type rateWindow struct {
+	buckets [rateWindowSize]uint64
+}
+
+func (w *rateWindow) add(sec int64, n int64) {
+	b := &w.buckets[sec%rateWindowSize]
+	for {
+		old := atomic.LoadUint64(b)
+		var v uint64
+		if int64(old>>32) == sec {
+			v = old + uint64(n)
+		} else {
+			// 过期的 bucket，重新开始计数
+			v = uint64(sec)<<32 | uint64(n)
+		}
+		if atomic.CompareAndSwapUint64(b, old, v) {
+			return
+		}
+	}
+}
+
+// rate returns the speed of the last window seconds before now (不包括 now 这一秒), 个/s。
+// elapsed 是开始计数以来的时间，不足 window 时按 elapsed 计算
+func (w *rateWindow) rate(now int64, window int64, elapsed int64) float64 {
+	if window > rateWindowSize {
+		window = rateWindowSize
+	}
+	if elapsed < window {
+		window = elapsed
+	}
+	if window <= 0 {
+		return 0
+	}
+	var sum int64
+	for sec := now - window; sec < now; sec++ {
+		if v := atomic.LoadUint64(&w.buckets[sec%rateWindowSize]); int64(v>>32) == sec {
+			sum += int64(v & 0xffffffff)
+		}
+	}
+	return float64(sum) / float64(window)
+}
@@ -0,0 +1,55 @@
+package tool
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRateWindow(t *testing.T) {
+	w := rateWindow{}
+	for sec := int64(100); sec < 110; sec++ {
+		w.add(sec, 10)
+	}
+	if r := w.rate(110, 10, 1000); r != 10 {
+		t.Fatal("unexpected 10s rate", r)
+	}
+	if r := w.rate(110, 60, 1000); r != 100.0/60 {
+		t.Fatal("unexpected 1m rate", r)
+	}
+	// 开始的时间不足一个窗口
+	if r := w.rate(110, 60, 10); r != 10 {
+		t.Fatal("unexpected rate of short elapsed", r)
+	}
+	// 当前这一秒不计算在内
+	w.add(110, 1000)
+	if r := w.rate(110, 10, 1000); r != 10 {
+		t.Fatal("unexpected rate with current second", r)
+	}
+
+	// 过期的 bucket 被覆盖
+	w.add(100+rateWindowSize, 5)
+	if r := w.rate(101+rateWindowSize, 1, 1000); r != 5 {
+		t.Fatal("unexpected rate after wrap", r)
+	}
+	if r := w.rate(110, 10, 1000); r != 9 {
+		t.Fatal("unexpected rate of overwritten bucket", r)
+	}
+}
+
+func TestRateWindow_Concurrent(t *testing.T) {
+	w := rateWindow{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				w.add(int64(1000+j%2), 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if r := w.rate(1002, 2, 1000); r != 8000 {
+		t.Fatal("unexpected rate", r)
+	}
+}
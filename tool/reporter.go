@@ -0,0 +1,238 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	xlog "github.com/sirupsen/logrus"
+)
+
+// Snapshot is the statistics of a Speedometer at a moment
+type Snapshot struct {
+	Name      string        `json:"name,omitempty"`
+	Finished  bool          `json:"finished"` // Close 时的最终统计
+	Time      time.Time     `json:"time"`
+	Processed int64         `json:"processed"` // 这次处理的
+	Before    int64         `json:"processed_before"`
+	Total     int64         `json:"total,omitempty"` // 可以是估计值，0 表示未知
+	Retried   int64         `json:"retried,omitempty"`
+	Elapsed   time.Duration `json:"elapsed_ns"` // 不包括暂停的时间
+	Speed     float64       `json:"speed"`      // 平均速度，个/s
+	Speed10s  float64       `json:"speed_10s"`
+	Speed1m   float64       `json:"speed_1m"`
+	Speed5m   float64       `json:"speed_5m"`
+	ETA       time.Duration `json:"eta_ns,omitempty"` // 预计剩余时间，见 HasETA
+	RateLimit float64       `json:"rate_limit,omitempty"`
+}
+
+// HasETA returns whether ETA can be estimated, 需要知道 Total 并且已经处理了一部分
+func (s Snapshot) HasETA() bool {
+	return !s.Finished && s.Total != 0 && s.Processed != 0 && s.Elapsed > 0
+}
+
+// Reporter receives the snapshots of Speedometer, 可能被并发调用
+type Reporter interface {
+	Report(s Snapshot)
+}
+
+// textReporter is the default Reporter, 输出一行文本日志
+type textReporter struct {
+	xl *xlog.Logger
+}
+
+func (r textReporter) Report(s Snapshot) {
+	msg := ""
+	if s.Name != "" {
+		msg += "[" + s.Name + "] "
+	}
+	if s.Finished {
+		// 名称，总处理量，总共用时，平均速度。
+		msg += "finished..."
+		msg += join("total", strconv.FormatInt(s.Processed+s.Before, 10))
+		msg += join("processed this", strconv.FormatInt(s.Processed, 10))
+		msg += join("processed before", strconv.FormatInt(s.Before, 10))
+		msg += join("processed total", strconv.FormatInt(s.Processed+s.Before, 10))
+		msg += join("use time", humanDuration(s.Elapsed))
+		if s.Elapsed > 0 {
+			msg += join("speed", formatSpeed(s.Speed))
+		}
+	} else {
+		// basic + 最近的速度 + 预计剩余时间(-h)
+		msg += "processing..."
+		msg += join("processed", strconv.FormatInt(s.Processed, 10))
+		if s.Elapsed > 0 {
+			msg += join("speed", formatSpeed(s.Speed))
+			msg += join("speed 10s", formatSpeed(s.Speed10s))
+			msg += join("speed 1m", formatSpeed(s.Speed1m))
+			msg += join("speed 5m", formatSpeed(s.Speed5m))
+		}
+		if s.HasETA() {
+			msg += join("left time", humanDuration(s.ETA))
+		}
+	}
+	if s.Retried != 0 {
+		msg += join("retried", strconv.FormatInt(s.Retried, 10))
+	}
+	if !s.Finished && s.RateLimit > 0 {
+		msg += join("rate limit", formatSpeed(s.RateLimit))
+	}
+	output(r.xl, msg)
+}
+
+// LogrusReporter writes snapshots as logrus fields
+type LogrusReporter struct {
+	Logger *xlog.Logger
+}
+
+func (r LogrusReporter) Report(s Snapshot) {
+	fields := xlog.Fields{
+		"processed":        s.Processed,
+		"processed_before": s.Before,
+		"elapsed":          s.Elapsed.String(),
+		"speed":            s.Speed,
+	}
+	if s.Name != "" {
+		fields["name"] = s.Name
+	}
+	if s.Total != 0 {
+		fields["total"] = s.Total
+	}
+	if s.Retried != 0 {
+		fields["retried"] = s.Retried
+	}
+	if s.Finished {
+		r.Logger.WithFields(fields).Info("finished")
+		return
+	}
+	fields["speed_10s"] = s.Speed10s
+	fields["speed_1m"] = s.Speed1m
+	fields["speed_5m"] = s.Speed5m
+	if s.HasETA() {
+		fields["eta"] = s.ETA.Round(time.Second).String()
+	}
+	if s.RateLimit > 0 {
+		fields["rate_limit"] = s.RateLimit
+	}
+	r.Logger.WithFields(fields).Info("processing")
+}
+
+// JSONLinesReporter writes a JSON line for each snapshot
+type JSONLinesReporter struct {
+	m sync.Mutex
+	w io.Writer
+	c io.Closer // 由 NewFileJSONLinesReporter 打开的文件
+}
+
+// NewJSONLinesReporter writes to w
+func NewJSONLinesReporter(w io.Writer) *JSONLinesReporter {
+	return &JSONLinesReporter{w: w}
+}
+
+// NewFileJSONLinesReporter appends to filename, 使用完后需要 Close
+func NewFileJSONLinesReporter(filename string) (r *JSONLinesReporter, err error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	return &JSONLinesReporter{w: f, c: f}, nil
+}
+
+func (r *JSONLinesReporter) Report(s Snapshot) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.w.Write(append(b, '\n'))
+}
+
+// Close closes the file opened by NewFileJSONLinesReporter
+func (r *JSONLinesReporter) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+const progressBarWidth = 30
+
+// ProgressBarReporter draws a progress bar, 是 TTY 时在同一行重绘，否则每次输出一行
+type ProgressBarReporter struct {
+	m   sync.Mutex
+	w   io.Writer
+	tty bool
+}
+
+// NewProgressBarReporter draws to f, f 为 nil 时使用 os.Stderr
+func NewProgressBarReporter(f *os.File) *ProgressBarReporter {
+	if f == nil {
+		f = os.Stderr
+	}
+	return &ProgressBarReporter{w: f, tty: isTerminal(f)}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (r *ProgressBarReporter) Report(s Snapshot) {
+	line := progressLine(s)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if !r.tty {
+		fmt.Fprintln(r.w, line)
+		return
+	}
+	// 回到行首并清除到行尾
+	fmt.Fprint(r.w, "\r"+line+"\x1b[K")
+	if s.Finished {
+		fmt.Fprintln(r.w)
+	}
+}
+
+func progressLine(s Snapshot) string {
+	var b strings.Builder
+	if s.Name != "" {
+		b.WriteString(s.Name + " ")
+	}
+	done := s.Processed + s.Before
+	if s.Total > 0 {
+		ratio := float64(done) / float64(s.Total)
+		if ratio > 1 {
+			ratio = 1
+		}
+		filled := int(ratio * progressBarWidth)
+		b.WriteString("[" + strings.Repeat("=", filled))
+		if filled < progressBarWidth {
+			b.WriteString(">" + strings.Repeat(" ", progressBarWidth-filled-1))
+		}
+		fmt.Fprintf(&b, "] %5.1f%% %d/%d", ratio*100, done, s.Total)
+	} else {
+		fmt.Fprintf(&b, "%d", done)
+	}
+	speed := s.Speed
+	if !s.Finished && s.Speed1m > 0 {
+		speed = s.Speed1m
+	}
+	fmt.Fprintf(&b, " %s/s", formatSpeed(speed))
+	if s.HasETA() {
+		b.WriteString(" ETA " + humanDuration(s.ETA))
+	}
+	if s.Finished {
+		b.WriteString(" done in " + humanDuration(s.Elapsed))
+	}
+	return b.String()
+}
+
+func formatSpeed(speed float64) string {
+	return strconv.FormatFloat(speed, 'f', 2, 64)
+}
@@ -0,0 +1,192 @@
+package tool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	xlog "github.com/sirupsen/logrus"
+)
+
+type memReporter struct {
+	m     sync.Mutex
+	snaps []Snapshot
+}
+
+func (r *memReporter) Report(s Snapshot) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.snaps = append(r.snaps, s)
+}
+
+func TestSpeedometer_Reporters(t *testing.T) {
+	r := &memReporter{}
+	clock := newFakeClock()
+	s := NewSpeedometer(xlog.New(), SpeedometerConfig{
+		Name:              "report",
+		Total:             1000,
+		ProcessedBefore:   100,
+		OutputNumInterval: 100,
+		Clock:             clock,
+		Reporters:         []Reporter{r},
+	})
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Second)
+		s.IncreaseN(50)
+	}
+	s.IncreaseRetry()
+	s.Close()
+
+	if len(r.snaps) != 3 {
+		t.Fatal("unexpected snapshots", r.snaps)
+	}
+	snap := r.snaps[0]
+	if snap.Name != "report" || snap.Finished || snap.Processed != 100 || snap.Before != 100 || snap.Total != 1000 {
+		t.Fatal("unexpected snapshot", snap)
+	}
+	if snap.Elapsed != 2*time.Second || snap.Speed != 50 || !snap.HasETA() {
+		t.Fatal("unexpected speed", snap)
+	}
+	// 最近一分钟只有 2s，每秒 50 个（不包括当前这一秒）
+	if snap.Speed1m != 25 || snap.ETA != 32*time.Second {
+		t.Fatal("unexpected eta", snap.Speed1m, snap.ETA)
+	}
+	final := r.snaps[2]
+	if !final.Finished || final.Processed != 200 || final.Retried != 1 || final.HasETA() {
+		t.Fatal("unexpected final snapshot", final)
+	}
+}
+
+type panicReporter struct{}
+
+func (panicReporter) Report(Snapshot) {
+	panic("report panic")
+}
+
+func TestSpeedometer_ReporterPanic(t *testing.T) {
+	// 一个 Reporter panic 不影响计数和其他的 Reporter
+	r := &memReporter{}
+	l := xlog.New()
+	l.Out = io.Discard
+	s := NewSpeedometer(l, SpeedometerConfig{
+		OutputNumInterval: 10,
+		Clock:             newFakeClock(),
+		Reporters:         []Reporter{panicReporter{}, r},
+	})
+	s.IncreaseN(10)
+	s.Close()
+	if s.Processed() != 10 || len(r.snaps) != 2 {
+		t.Fatal("unexpected snapshots", s.Processed(), r.snaps)
+	}
+}
+
+func TestLogrusReporter(t *testing.T) {
+	l := xlog.New()
+	l.Out = io.Discard
+	var entries []*xlog.Entry
+	l.AddHook(hookFunc(func(e *xlog.Entry) { entries = append(entries, e) }))
+
+	r := LogrusReporter{Logger: l}
+	r.Report(Snapshot{Name: "a", Processed: 10, Total: 100, Elapsed: time.Second, Speed: 10, Speed1m: 10, ETA: 9 * time.Second})
+	r.Report(Snapshot{Finished: true, Processed: 100, Elapsed: 10 * time.Second, Speed: 10})
+
+	if len(entries) != 2 {
+		t.Fatal("unexpected entries", entries)
+	}
+	if entries[0].Message != "processing" || entries[0].Data["eta"] != "9s" || entries[0].Data["name"] != "a" {
+		t.Fatal("unexpected processing entry", entries[0].Message, entries[0].Data)
+	}
+	if entries[1].Message != "finished" || entries[1].Data["processed"] != int64(100) {
+		t.Fatal("unexpected finished entry", entries[1].Message, entries[1].Data)
+	}
+	if _, ok := entries[1].Data["eta"]; ok {
+		t.Fatal("unexpected eta in finished entry")
+	}
+}
+
+type hookFunc func(e *xlog.Entry)
+
+func (h hookFunc) Levels() []xlog.Level {
+	return xlog.AllLevels
+}
+
+func (h hookFunc) Fire(e *xlog.Entry) error {
+	h(e)
+	return nil
+}
+
+func TestJSONLinesReporter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "stats.jsonl")
+	r, err := NewFileJSONLinesReporter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Report(Snapshot{Processed: 10, Elapsed: time.Second, Speed: 10})
+	r.Report(Snapshot{Finished: true, Processed: 20, Elapsed: 2 * time.Second, Speed: 10})
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var snaps []Snapshot
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s Snapshot
+		if err = json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		snaps = append(snaps, s)
+	}
+	if len(snaps) != 2 || snaps[0].Processed != 10 || !snaps[1].Finished || snaps[1].Elapsed != 2*time.Second {
+		t.Fatal("unexpected snapshots", snaps)
+	}
+}
+
+func TestProgressBarReporter(t *testing.T) {
+	snap := Snapshot{Name: "job", Processed: 40, Before: 10, Total: 100, Elapsed: 5 * time.Second, Speed: 8, Speed1m: 10, ETA: 5 * time.Second}
+	if line := progressLine(snap); line != "job [===============>              ]  50.0% 50/100 10.00/s ETA 5s" {
+		t.Fatalf("unexpected line %q", line)
+	}
+	if line := progressLine(Snapshot{Processed: 7, Finished: true, Elapsed: time.Second, Speed: 7}); line != "7 7.00/s done in 1s" {
+		t.Fatalf("unexpected line %q", line)
+	}
+
+	// TTY 时在同一行重绘
+	buf := &bytes.Buffer{}
+	r := &ProgressBarReporter{w: buf, tty: true}
+	r.Report(snap)
+	snap.Finished = true
+	r.Report(snap)
+	out := buf.String()
+	if strings.Count(out, "\r") != 2 || strings.Count(out, "\x1b[K") != 2 || !strings.HasSuffix(out, "\n") || strings.Count(out, "\n") != 1 {
+		t.Fatalf("unexpected tty output %q", out)
+	}
+
+	// 不是 TTY 时每次输出一行
+	f, err := os.Create(filepath.Join(t.TempDir(), "progress.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r = NewProgressBarReporter(f)
+	if r.tty {
+		t.Fatal("regular file is not a tty")
+	}
+	r.Report(snap)
+	r.Report(snap)
+	b, _ := os.ReadFile(f.Name())
+	if strings.Count(string(b), "\n") != 2 || strings.Contains(string(b), "\r") {
+		t.Fatalf("unexpected output %q", b)
+	}
+}
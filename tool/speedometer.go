@@ -1,7 +1,11 @@
 package tool
 
 import (
+	"math"
+	"runtime/debug"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	xlog "github.com/sirupsen/logrus"
@@ -9,140 +13,286 @@ import (
 
 // SpeedometerConfig is Speedometer Config
 type SpeedometerConfig struct {
-	Total                    int64 `json:"total"`
-	ProcessedBefore          int64 `json:"processed_before"`
-	OutputTimeIntervalSecond int64 `json:"output_time_interval_second"`
-	OutputNumInterval        int64 `json:"output_num_interval"`
+	Name                     string `json:"name"` // 输出时的前缀，用于区分多个 Speedometer
+	Total                    int64  `json:"total"`
+	ProcessedBefore          int64  `json:"processed_before"`
+	OutputTimeIntervalSecond int64  `json:"output_time_interval_second"`
+	OutputNumInterval        int64  `json:"output_num_interval"`
+
+	OutputInterval time.Duration `json:"output_interval"` // 按时间输出的间隔，可以小于 1s，不为 0 时代替 OutputTimeIntervalSecond
+	Clock          Clock         `json:"-"`               // 为 nil 时使用 RealClock
+	Reporters      []Reporter    `json:"-"`               // 为空时输出文本日志到 xl
 }
 
 // Speedometer is a util tool for counting speed and processingStatics statics regularly
 // 处理数量/时间，速度单位是：个/s，精确到个位数
 // 每隔多长时间；每隔多少数量输出一次当前处理信息
 // 支持续处理
+// 可以并发使用：计数使用 atomic，按时间的输出由一个 goroutine 负责，需要调用 Close 停止
+// 时间都是相对于 base 的 time.Duration（单调时钟），int64 保存以便 atomic 访问
 type Speedometer struct {
-	xl                       *xlog.Logger
-	startTime                int64
-	outputTimeIntervalSecond int64 // s
-	lastOutputTime           int64
-	outputNumInterval        int64
-	lastOutputNum            int64
-	total                    int64 // 可以是估计值
-	processed                int64 // 这次处理的
-	processedBefore          int64 // 之前处理的
+	xl                *xlog.Logger
+	name              string
+	clock             Clock
+	base              time.Time     // 创建的时间
+	startTime         int64         // 开始的时间，atomic 访问
+	outputInterval    time.Duration // 按时间输出的间隔
+	outputNumInterval int64
+	lastOutputNum     int64      // atomic 访问
+	total             int64      // 可以是估计值
+	processed         int64      // 这次处理的，atomic 访问
+	processedBefore   int64      // 之前处理的
+	retried           int64      // 重试的次数，atomic 访问
+	rateLimit         uint64     // 当前的限速（个/s），float64 bits，atomic 访问
+	pausedAt          int64      // 暂停的开始时间 + 1，0 表示没有暂停，atomic 访问
+	pausedTime        int64      // 暂停的总时间，不包括正在进行的暂停，atomic 访问
+	window            rateWindow // 最近 5 分钟每秒处理的数量
+	reporters         []Reporter
+
+	closeOnce sync.Once
+	stop      chan struct{} // Close 时关闭，通知输出的 goroutine 退出
+	stopped   chan struct{} // 输出的 goroutine 退出后关闭
 }
 
 // NewSimpleSpeedometer return the most simple sc.
 func NewSimpleSpeedometer(xl *xlog.Logger, timeIntervalSecond int64) (s *Speedometer) {
-
-	s = &Speedometer{
-		xl:                       xl,
-		startTime:                time.Now().Unix(),
-		lastOutputTime:           time.Now().Unix(),
-		outputTimeIntervalSecond: timeIntervalSecond,
-	}
-	return
+	return NewSpeedometer(xl, SpeedometerConfig{OutputTimeIntervalSecond: timeIntervalSecond})
 }
 
 // NewSpeedometer is a constructor
 func NewSpeedometer(xl *xlog.Logger, cfg SpeedometerConfig) (s *Speedometer) {
 
+	clock := cfg.Clock
+	if clock == nil {
+		clock = RealClock{}
+	}
+	outputInterval := cfg.OutputInterval
+	if outputInterval == 0 {
+		outputInterval = time.Duration(cfg.OutputTimeIntervalSecond) * time.Second
+	}
 	s = &Speedometer{
-		xl:                       xl,
-		startTime:                time.Now().Unix(),
-		total:                    cfg.Total,
-		outputTimeIntervalSecond: cfg.OutputTimeIntervalSecond,
-		lastOutputTime:           time.Now().Unix(),
-		outputNumInterval:        cfg.OutputNumInterval,
-		processedBefore:          cfg.ProcessedBefore,
+		xl:                xl,
+		name:              cfg.Name,
+		clock:             clock,
+		base:              clock.Now(),
+		total:             cfg.Total,
+		outputInterval:    outputInterval,
+		outputNumInterval: cfg.OutputNumInterval,
+		processedBefore:   cfg.ProcessedBefore,
+		reporters:         cfg.Reporters,
 	}
+	if len(s.reporters) == 0 {
+		s.reporters = []Reporter{textReporter{xl}}
+	}
+	s.startOutput()
 	return
 }
 
+// now returns the time since base
+func (s *Speedometer) now() time.Duration {
+	return s.clock.Now().Sub(s.base)
+}
+
+// startOutput 启动按时间输出的 goroutine
+func (s *Speedometer) startOutput() {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	if s.outputInterval <= 0 {
+		close(s.stopped)
+		return
+	}
+	ticker := s.clock.NewTicker(s.outputInterval)
+	go func() {
+		defer close(s.stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C():
+				s.processingStatics()
+			}
+		}
+	}()
+}
+
 func output(xl *xlog.Logger, msg string) {
 	xl.Info(msg)
 }
 
-// basic + 预计剩余时间(-h)
-// todo: 最近一分钟速度
+// processingStatics 输出当前的统计
 func (s *Speedometer) processingStatics() {
+	s.report(s.snapshot(false))
+}
+
+// 名称，总处理量，总共用时，平均速度。
+func (s *Speedometer) overallStatics() {
+	s.report(s.snapshot(true))
+}
+
+func (s *Speedometer) report(snap Snapshot) {
+	for _, r := range s.reporters {
+		s.safeReport(r, snap)
+	}
+}
 
-	var msg string
-	msg += "processing..."
-
-	msg += join("processed", strconv.FormatInt(s.processed, 10))
-	now := time.Now().Unix()
-
-	usedTime := now - s.startTime
-	if usedTime != 0 {
-		speed := calSpeed(s.processed, usedTime)
-		msg += join("speed", speed)
-		if s.total != 0 {
-			leftNum := s.total - s.processed - s.processedBefore
-			s.xl.Debug(s.processed, usedTime, leftNum)
-			leftTime := leftNum * usedTime / s.processed
-			leftHumanTime := humanTime(leftTime)
-			msg += join("left time", leftHumanTime)
+// safeReport 调用 Reporter，Reporter panic 时只记录日志，不影响计数的 goroutine
+func (s *Speedometer) safeReport(r Reporter, snap Snapshot) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.xl.Errorf("reporter panic: %v\n%s", v, debug.Stack())
 		}
+	}()
+	r.Report(snap)
+}
+
+// Snapshot returns the current statistics
+func (s *Speedometer) Snapshot() Snapshot {
+	return s.snapshot(false)
+}
+
+func (s *Speedometer) snapshot(finished bool) Snapshot {
+	now := s.now()
+	snap := Snapshot{
+		Name:      s.name,
+		Finished:  finished,
+		Time:      s.clock.Now(),
+		Processed: s.Processed(),
+		Before:    s.processedBefore,
+		Total:     s.total,
+		Retried:   s.Retried(),
+		Elapsed:   s.usedTime(now),
+		RateLimit: s.RateLimit(),
 	}
-	output(s.xl, msg)
+	if snap.Elapsed > 0 {
+		snap.Speed = float64(snap.Processed) / snap.Elapsed.Seconds()
+		snap.Speed10s = s.rate(now, 10*time.Second)
+		snap.Speed1m = s.rate(now, time.Minute)
+		snap.Speed5m = s.rate(now, 5*time.Minute)
+	}
+	if snap.HasETA() {
+		leftNum := snap.Total - snap.Processed - snap.Before
+		s.xl.Debug(snap.Processed, snap.Elapsed, leftNum)
+		snap.ETA = s.leftTime(now, snap.Elapsed, snap.Processed, leftNum)
+	}
+	return snap
 }
 
-// 名称，总处理量，总共用时，平均速度。
-func (s *Speedometer) overallStatics() {
-	var msg string
-	msg += "finished..."
-	msg += join("total", strconv.FormatInt(s.processed+s.processedBefore, 10))
-	msg += join("processed this", strconv.FormatInt(s.processed, 10))
-	msg += join("processed before", strconv.FormatInt(s.processedBefore, 10))
-	msg += join("processed total", strconv.FormatInt(s.processed+s.processedBefore, 10))
-	now := time.Now().Unix()
-	t := now - s.startTime
-	msg += join("use time", humanTime(t))
+// usedTime 不包括暂停的时间
+func (s *Speedometer) usedTime(now time.Duration) time.Duration {
+	return s.activeTime(now) - time.Duration(atomic.LoadInt64(&s.startTime))
+}
 
-	if t != 0 {
-		speed := calSpeed(s.processed, t)
-		msg += join("speed", speed)
+// activeTime 是去掉暂停时间的时钟，暂停时不前进。window 按照它分桶，暂停的时间不会被当作速度为 0
+func (s *Speedometer) activeTime(now time.Duration) time.Duration {
+	t := now - time.Duration(atomic.LoadInt64(&s.pausedTime))
+	if pausedAt := atomic.LoadInt64(&s.pausedAt); pausedAt != 0 {
+		t -= now - time.Duration(pausedAt-1)
 	}
-	output(s.xl, msg)
+	return t
 }
 
-func calSpeed(num int64, t int64) string {
-	speed := float64(num) / float64(t)
-	return strconv.FormatFloat(speed, 'f', 2, 64)
+// leftTime 按照最近一分钟的速度估计，刚开始还没有最近的速度时按照平均速度
+func (s *Speedometer) leftTime(now, usedTime time.Duration, processed, leftNum int64) time.Duration {
+	if recent := s.rate(now, time.Minute); recent > 0 {
+		return time.Duration(float64(leftNum) / recent * float64(time.Second))
+	}
+	return time.Duration(float64(usedTime) * float64(leftNum) / float64(processed))
 }
 
-func (s *Speedometer) outputCheck() {
-	// 时间和数量分开检测。
-	if s.outputNumInterval != 0 && s.processed-s.lastOutputNum >= s.outputNumInterval {
-		s.lastOutputNum = s.processed
-		s.processingStatics()
+// Elapsed returns the time used, 不包括暂停的时间
+func (s *Speedometer) Elapsed() time.Duration {
+	return s.usedTime(s.now())
+}
+
+// outputCheck 按数量输出，按时间的输出见 startOutput。
+// 多个 goroutine 同时越过间隔时，只有 CAS 成功的那个输出
+func (s *Speedometer) outputCheck(processed int64) {
+	if s.outputNumInterval == 0 {
+		return
 	}
-	if s.outputTimeIntervalSecond != 0 && time.Now().Unix()-s.lastOutputTime >= s.outputTimeIntervalSecond {
-		s.lastOutputTime = time.Now().Unix()
+	last := atomic.LoadInt64(&s.lastOutputNum)
+	if processed-last >= s.outputNumInterval && atomic.CompareAndSwapInt64(&s.lastOutputNum, last, processed) {
 		s.processingStatics()
 	}
 }
 
 // Start 开始计时。否则，会使用 New 的时间作为开始时间。
 func (s *Speedometer) Start() {
-	s.startTime = time.Now().Unix()
+	atomic.StoreInt64(&s.startTime, int64(s.now()))
 }
 
-// Increase add 1
+// Increase add 1, it can be called concurrently
 func (s *Speedometer) Increase() {
-	s.processed++
-	s.outputCheck()
+	s.IncreaseN(1)
 }
 
-// IncreaseN add n
+// IncreaseN add n, it can be called concurrently
 func (s *Speedometer) IncreaseN(n int) {
-	s.processed += int64(n)
-	s.outputCheck()
+	s.window.add(int64(s.activeTime(s.now())/time.Second), int64(n))
+	s.outputCheck(atomic.AddInt64(&s.processed, int64(n)))
+}
+
+// Rate returns the speed of the last window (最长 5 分钟，精确到秒，不包括当前这一秒和暂停的时间), 个/s
+func (s *Speedometer) Rate(window time.Duration) float64 {
+	return s.rate(s.now(), window)
+}
+
+func (s *Speedometer) rate(now time.Duration, window time.Duration) float64 {
+	active := s.activeTime(now)
+	elapsed := active - time.Duration(atomic.LoadInt64(&s.startTime))
+	return s.window.rate(int64(active/time.Second), int64(window/time.Second), int64(elapsed/time.Second))
+}
+
+// IncreaseRetry add 1 to retried, it can be called concurrently
+func (s *Speedometer) IncreaseRetry() {
+	atomic.AddInt64(&s.retried, 1)
+}
+
+// Retried returns the number of retries
+func (s *Speedometer) Retried() int64 {
+	return atomic.LoadInt64(&s.retried)
+}
+
+// SetRateLimit sets the effective rate limit to show, it can be called concurrently
+func (s *Speedometer) SetRateLimit(rate float64) {
+	atomic.StoreUint64(&s.rateLimit, math.Float64bits(rate))
+}
+
+// RateLimit returns the effective rate limit, 0 表示不限制
+func (s *Speedometer) RateLimit() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.rateLimit))
+}
+
+// Pause stops the clock, 暂停的时间不计入速度和剩余时间；可以并发调用
+func (s *Speedometer) Pause() {
+	atomic.CompareAndSwapInt64(&s.pausedAt, 0, int64(s.now())+1)
+}
+
+// Resume restarts the clock stopped by Pause
+func (s *Speedometer) Resume() {
+	if pausedAt := atomic.SwapInt64(&s.pausedAt, 0); pausedAt != 0 {
+		atomic.AddInt64(&s.pausedTime, int64(s.now())-(pausedAt-1))
+	}
 }
 
-// Close show overall statics and record processed to file
+// Paused returns whether the clock is stopped
+func (s *Speedometer) Paused() bool {
+	return atomic.LoadInt64(&s.pausedAt) != 0
+}
+
+// Processed returns the number processed this time
+func (s *Speedometer) Processed() int64 {
+	return atomic.LoadInt64(&s.processed)
+}
+
+// Close stops the time-based output and show overall statics, 多次调用只输出一次
 func (s *Speedometer) Close() (err error) {
-	s.overallStatics()
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.stopped
+		s.overallStatics()
+	})
 	return
 }
 
@@ -165,6 +315,14 @@ func humanTime(t int64) string {
 	return head + strconv.FormatInt(t, 10) + name
 }
 
+// humanDuration 不足 1s 时精确到 ms，否则同 humanTime
+func humanDuration(d time.Duration) string {
+	if d > -time.Second && d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return humanTime(int64(d / time.Second))
+}
+
 func join(key string, val string) (s string) {
 	s = key + ": " + val + ", "
 	return
@@ -0,0 +1,231 @@
+package tool
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	xlog "github.com/sirupsen/logrus"
+)
+
+// countHook 统计输出的次数
+type countHook struct {
+	processing int64
+	finished   int64
+}
+
+func (h *countHook) Levels() []xlog.Level {
+	return xlog.AllLevels
+}
+
+func (h *countHook) Fire(e *xlog.Entry) error {
+	if strings.Contains(e.Message, "processing...") {
+		atomic.AddInt64(&h.processing, 1)
+	}
+	if strings.Contains(e.Message, "finished...") {
+		atomic.AddInt64(&h.finished, 1)
+	}
+	return nil
+}
+
+func newCountLogger() (*xlog.Logger, *countHook) {
+	l := xlog.New()
+	l.Out = io.Discard
+	hook := &countHook{}
+	l.AddHook(hook)
+	return l, hook
+}
+
+// go test -race 检查并发使用时没有 data race
+func TestSpeedometer_Concurrent(t *testing.T) {
+	l, hook := newCountLogger()
+	s := NewSpeedometer(l, SpeedometerConfig{
+		Total:                    1000000,
+		OutputTimeIntervalSecond: 1,
+		OutputNumInterval:        1000,
+	})
+
+	const goroutines, times = 32, 5000
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < times; j++ {
+				if j%2 == 0 {
+					s.Increase()
+				} else {
+					s.IncreaseN(1)
+				}
+				if j%100 == 0 {
+					s.IncreaseRetry()
+					s.SetRateLimit(float64(j))
+					s.Processed()
+				}
+				if i == 0 && j%1000 == 0 {
+					s.Pause()
+					s.Resume()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if s.Processed() != goroutines*times {
+		t.Fatal("unexpected processed", s.Processed())
+	}
+	if s.Retried() != goroutines*times/100 {
+		t.Fatal("unexpected retried", s.Retried())
+	}
+	// 每次越过间隔最多输出一次
+	if n := atomic.LoadInt64(&hook.processing); n == 0 || n > goroutines*times/1000 {
+		t.Fatal("unexpected output times", n)
+	}
+
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			s.Close()
+		}()
+	}
+	wg.Wait()
+	if hook.finished != 1 {
+		t.Fatal("unexpected finished output times", hook.finished)
+	}
+}
+
+// recordHook 记录输出的内容
+type recordHook struct {
+	m    sync.Mutex
+	msgs []string
+}
+
+func (h *recordHook) Levels() []xlog.Level {
+	return xlog.AllLevels
+}
+
+func (h *recordHook) Fire(e *xlog.Entry) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.msgs = append(h.msgs, e.Message)
+	return nil
+}
+
+func (h *recordHook) messages() []string {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return append([]string(nil), h.msgs...)
+}
+
+func newFakeSpeedometer(cfg SpeedometerConfig) (*Speedometer, *fakeClock, *recordHook) {
+	l := xlog.New()
+	l.Out = io.Discard
+	hook := &recordHook{}
+	l.AddHook(hook)
+	clock := newFakeClock()
+	cfg.Clock = clock
+	return NewSpeedometer(l, cfg), clock, hook
+}
+
+func TestSpeedometer_SubSecond(t *testing.T) {
+	s, clock, hook := newFakeSpeedometer(SpeedometerConfig{})
+	s.IncreaseN(50)
+	clock.Advance(500 * time.Millisecond)
+
+	if s.Elapsed() != 500*time.Millisecond {
+		t.Fatal("unexpected elapsed", s.Elapsed())
+	}
+	s.Close()
+	msgs := hook.messages()
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "use time: 500ms, ") || !strings.Contains(msgs[0], "speed: 100.00, ") {
+		t.Fatal("unexpected overall statics", msgs)
+	}
+}
+
+func TestSpeedometer_OutputInterval(t *testing.T) {
+	s, clock, hook := newFakeSpeedometer(SpeedometerConfig{OutputInterval: 100 * time.Millisecond})
+	s.IncreaseN(10)
+	clock.Advance(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		clock.Advance(100 * time.Millisecond)
+	}
+	// tick 被接收之后才会输出，Close 等待输出的 goroutine 退出
+	s.Close()
+
+	msgs := hook.messages()
+	if len(msgs) != 4 {
+		t.Fatal("unexpected output times", msgs)
+	}
+	if !strings.Contains(msgs[0], "speed: 66.67, ") {
+		t.Fatal("unexpected processing statics", msgs[0])
+	}
+}
+
+func TestSpeedometer_Pause(t *testing.T) {
+	s, clock, _ := newFakeSpeedometer(SpeedometerConfig{})
+	clock.Advance(time.Second)
+	s.Pause()
+	clock.Advance(10 * time.Second)
+	if s.Elapsed() != time.Second {
+		t.Fatal("unexpected elapsed while paused", s.Elapsed())
+	}
+	s.Resume()
+	clock.Advance(time.Second)
+	if s.Elapsed() != 2*time.Second {
+		t.Fatal("unexpected elapsed after resume", s.Elapsed())
+	}
+}
+
+func TestSpeedometer_RecentRate(t *testing.T) {
+	s, clock, _ := newFakeSpeedometer(SpeedometerConfig{Total: 100000})
+	// 前 60s 每秒 100 个，后 60s 每秒 10 个
+	for i := 0; i < 120; i++ {
+		if i < 60 {
+			s.IncreaseN(100)
+		} else {
+			s.IncreaseN(10)
+		}
+		clock.Advance(time.Second)
+	}
+	if r := s.Rate(10 * time.Second); r != 10 {
+		t.Fatal("unexpected 10s rate", r)
+	}
+	if r := s.Rate(5 * time.Minute); r != 6600.0/120 {
+		t.Fatal("unexpected 5m rate", r)
+	}
+	// 剩余时间按照最近一分钟的速度估计
+	now := s.now()
+	left := s.leftTime(now, s.usedTime(now), s.Processed(), 1000)
+	if left != 100*time.Second {
+		t.Fatal("unexpected left time", left)
+	}
+}
+
+func TestSpeedometer_PauseRecentRate(t *testing.T) {
+	s, clock, _ := newFakeSpeedometer(SpeedometerConfig{Total: 1200})
+	for i := 0; i < 60; i++ {
+		s.IncreaseN(10)
+		clock.Advance(time.Second)
+	}
+	if snap := s.Snapshot(); snap.ETA != time.Minute {
+		t.Fatal("unexpected ETA before pause", snap.ETA)
+	}
+
+	// 暂停的时间不计入最近的速度
+	s.Pause()
+	clock.Advance(time.Minute)
+	s.Resume()
+	s.IncreaseN(10)
+	clock.Advance(time.Second)
+	snap := s.Snapshot()
+	if snap.Speed1m != 10 {
+		t.Fatal("unexpected 1m speed after pause", snap.Speed1m)
+	}
+	if snap.ETA != 59*time.Second {
+		t.Fatal("unexpected ETA after pause", snap.ETA)
+	}
+}